@@ -0,0 +1,319 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pnelson/cli"
+)
+
+// agentDirName is the name of the directory within the machine
+// data directory that holds the agent Unix socket. It is only
+// accessible to its owner such that the socket is never exposed
+// to other users, even before its own permissions are set.
+const agentDirName = "agent"
+
+// agentSocketName is the name of the agent Unix socket
+// within the agent directory.
+const agentSocketName = "agent.sock"
+
+// agentStartTimeout is how long a detached agent has to start listening.
+const agentStartTimeout = 5 * time.Second
+
+// agent represents a connection multiplexer that holds a single
+// SSH connection to the machine open and serves channels on it
+// to other abx processes over a Unix socket.
+//
+// Local processes speak SSH to the agent, authenticating with
+// the machine key pair, such that sessions they open behave
+// exactly as if they had dialed the machine directly.
+type agent struct {
+	client  *client
+	config  *ssh.ServerConfig
+	timeout time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	active   int
+	timer    *time.Timer
+}
+
+func (c *client) agent(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	c.conn.direct = true
+	filename := c.agentSocket()
+	conn, err := net.Dial("unix", filename)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("Agent for machine '%s' is already running.", c.flags.host)
+	}
+	if c.flags.agent.detach {
+		return c.detachAgent()
+	}
+	a, err := newAgent(c, c.flags.agent.timeout)
+	if err != nil {
+		return err
+	}
+	l, err := listenAgent(filename)
+	if err != nil {
+		return err
+	}
+	c.verbose("Agent listening on '%s'.", filename)
+	return a.serve(l)
+}
+
+// detachAgent runs the agent in a background process that outlives
+// this one and returns once the agent is listening.
+func (c *client) detachAgent() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, "-host", c.flags.host, "-port", c.flags.port, "agent", "-detach=false", "-timeout", c.flags.agent.timeout.String())
+	cmd.Env = append(os.Environ(), "ACROBOX_HOME="+c.config.Home)
+	cmd.SysProcAttr = detachAttr()
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	filename := c.agentSocket()
+	deadline := time.Now().Add(agentStartTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("unix", filename)
+		if err == nil {
+			conn.Close()
+			c.verbose("Agent listening on '%s' with process ID %d.", filename, cmd.Process.Pid)
+			return nil
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("Agent for machine '%s' exited on startup. Run 'abx agent' to see why.", c.flags.host)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	cmd.Process.Kill()
+	return fmt.Errorf("Agent for machine '%s' did not start within %s.", c.flags.host, agentStartTimeout)
+}
+
+func (c *client) agentSocket() string {
+	return filepath.Join(c.config.Home, c.flags.host, agentDirName, agentSocketName)
+}
+
+// listenAgent listens on the agent socket at filename, replacing
+// any stale socket left behind by an agent that did not exit cleanly.
+func listenAgent(filename string) (net.Listener, error) {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(filename)
+	l, err := net.Listen("unix", filename)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(filename, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// dialAgent returns a new SSH connection to the machine
+// multiplexed through the agent.
+func (c *client) dialAgent(ctx context.Context) (*ssh.Client, error) {
+	filename := c.agentSocket()
+	d := net.Dialer{Timeout: time.Second}
+	nconn, err := d.DialContext(ctx, "unix", filename)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := c.getPrivateKey()
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(privateKey)},
+		HostKeyCallback: ssh.FixedHostKey(privateKey.PublicKey()),
+	}
+	s, err := newClientConn(ctx, nconn, filename, config)
+	if err != nil {
+		return nil, err
+	}
+	c.verbose("Connected through agent '%s'.", filename)
+	return s, nil
+}
+
+// newAgent returns a new agent that exits after
+// being idle for the timeout duration.
+func newAgent(c *client, timeout time.Duration) (*agent, error) {
+	privateKey, err := c.getPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	publicKey := privateKey.PublicKey().Marshal()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, pub ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(pub.Marshal(), publicKey) {
+				return nil, errors.New("unauthorized")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(privateKey)
+	a := &agent{
+		client:  c,
+		config:  config,
+		timeout: timeout,
+	}
+	return a, nil
+}
+
+// serve accepts connections on l until the agent is idle
+// for longer than the timeout.
+func (a *agent) serve(l net.Listener) error {
+	defer a.client.close()
+	a.mu.Lock()
+	a.listener = l
+	a.timer = time.AfterFunc(a.timeout, a.expire)
+	a.mu.Unlock()
+	for {
+		nconn, err := l.Accept()
+		if err != nil {
+			a.mu.Lock()
+			expired := a.listener == nil
+			a.mu.Unlock()
+			if expired {
+				return nil
+			}
+			return err
+		}
+		a.mu.Lock()
+		a.active++
+		a.timer.Stop()
+		a.mu.Unlock()
+		go a.handleConn(nconn)
+	}
+}
+
+// expire closes the listener if no connections are active.
+func (a *agent) expire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active > 0 || a.listener == nil {
+		return
+	}
+	a.client.verbose("Agent idle for %s, exiting.", a.timeout)
+	a.listener.Close()
+	a.listener = nil
+}
+
+func (a *agent) handleConn(nconn net.Conn) {
+	defer func() {
+		a.mu.Lock()
+		a.active--
+		if a.active == 0 {
+			a.timer.Reset(a.timeout)
+		}
+		a.mu.Unlock()
+	}()
+	sconn, chans, reqs, err := ssh.NewServerConn(nconn, a.config)
+	if err != nil {
+		nconn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		go a.handleChannel(nch)
+	}
+}
+
+func (a *agent) handleChannel(nch ssh.NewChannel) {
+	var remote ssh.Channel
+	var remoteReqs <-chan *ssh.Request
+	fn := func(s *ssh.Client) error {
+		var err error
+		remote, remoteReqs, err = s.OpenChannel(nch.ChannelType(), nch.ExtraData())
+		return err
+	}
+	err := a.client.connect(fn)
+	if err != nil {
+		oerr, ok := err.(*ssh.OpenChannelError)
+		if ok {
+			nch.Reject(oerr.Reason, oerr.Message)
+			return
+		}
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	local, localReqs, err := nch.Accept()
+	if err != nil {
+		remote.Close()
+		return
+	}
+	proxyChannel(local, localReqs, remote, remoteReqs)
+}
+
+// proxyChannel copies data and requests between the local and
+// remote channels until the remote channel is closed.
+func proxyChannel(local ssh.Channel, localReqs <-chan *ssh.Request, remote ssh.Channel, remoteReqs <-chan *ssh.Request) {
+	defer remote.Close()
+	defer local.Close()
+	go func() {
+		forwardRequests(remote, localReqs)
+		remote.Close()
+	}()
+	go func() {
+		io.Copy(remote, local)
+		remote.CloseWrite()
+	}()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(local, remote)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(local.Stderr(), remote.Stderr())
+	}()
+	forwardRequests(local, remoteReqs)
+	wg.Wait()
+	local.CloseWrite()
+}
+
+// forwardRequests sends each request to ch and relays the reply.
+func forwardRequests(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			req.Reply(ok && err == nil, nil)
+		}
+	}
+}
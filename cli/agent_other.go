@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package cli
+
+import "syscall"
+
+// detachAttr returns the attributes of a detached agent process.
+func detachAttr() *syscall.SysProcAttr {
+	return nil
+}
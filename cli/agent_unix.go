@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package cli
+
+import "syscall"
+
+// detachAttr returns the attributes of a detached agent process.
+// The agent runs in a new session such that it is not sent the
+// hangup signal when the terminal that started it is closed.
+func detachAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
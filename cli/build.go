@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pnelson/cli"
+)
+
+// buildDockerfile is the entry name of a Dockerfile outside
+// of the build context when added to the context archive.
+const buildDockerfile = ".abx.Dockerfile"
+
+// ignorePattern represents a .dockerignore pattern.
+type ignorePattern struct {
+	re        *regexp.Regexp
+	exclusion bool
+}
+
+// build streams the build context to docker build on the machine.
+func (c *client) build(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	image := c.flags.build.tag
+	if c.flags.build.deploy && image == "" {
+		return fmt.Errorf("Flag 'tag' is required to deploy the built image.")
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("Build context '%s' is not a directory.", args[0])
+	}
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if c.flags.build.file != "" {
+		dockerfile, err = filepath.Abs(c.flags.build.file)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = os.Stat(dockerfile)
+	if err != nil {
+		return fmt.Errorf("Dockerfile '%s' does not exist.", dockerfile)
+	}
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(dir, dockerfile)
+	name = filepath.ToSlash(name)
+	if err != nil || name == ".." || strings.HasPrefix(name, "../") {
+		name = ""
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, dir, dockerfile, name, patterns))
+	}()
+	defer pr.Close()
+	if name == "" {
+		name = buildDockerfile
+	}
+	err = c.runWithInput(pr, "docker", buildArgs(name, image, c.flags.build.deploy)...)
+	if err != nil {
+		return err
+	}
+	if !c.flags.build.deploy {
+		return nil
+	}
+	image = imageName(image)
+	unlock, err := c.lock("deploy " + image)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return c.release(image, []string{image})
+}
+
+// buildArgs returns the docker build arguments to build the context
+// with the Dockerfile name and tag the image, if any. Deployed images
+// are also tagged as latest as deploys always release the latest tag.
+func buildArgs(name, image string, deploy bool) []string {
+	args := []string{"build", "-f", name}
+	if image != "" {
+		args = append(args, "-t", image)
+	}
+	latest := imageName(image) + ":latest"
+	if deploy && image != imageName(image) && image != latest {
+		args = append(args, "-t", latest)
+	}
+	return append(args, "-")
+}
+
+// writeContext writes the build context rooted at dir to w as a tar
+// stream without the entries matched by the .dockerignore patterns.
+//
+// The Dockerfile and .dockerignore files are always sent, as with
+// docker build. If the Dockerfile is outside of the build context,
+// as denoted by an empty name, it is added as buildDockerfile.
+func writeContext(w io.Writer, dir, dockerfile, name string, patterns []ignorePattern) error {
+	skip := func(filename string, fi os.FileInfo) bool {
+		if filename == name || filename == ".dockerignore" {
+			return false
+		}
+		return isIgnored(patterns, filename)
+	}
+	if hasExclusion(patterns) {
+		// Directories must be walked if an exclusion
+		// pattern may re-include any of their contents.
+		skip = func(filename string, fi os.FileInfo) bool {
+			if filename == name || filename == ".dockerignore" || fi.IsDir() {
+				return false
+			}
+			return isIgnored(patterns, filename)
+		}
+	}
+	tw := tar.NewWriter(w)
+	err := addTree(tw, dir, dir, skip)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		b, err := os.ReadFile(dockerfile)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: buildDockerfile, Mode: 0644, Size: int64(len(b))}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readDockerignore returns the patterns of the .dockerignore
+// file in dir, if any.
+func readDockerignore(dir string) ([]ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseDockerignore(f)
+}
+
+// parseDockerignore returns the patterns read from r. Lines
+// starting with '#' are comments and blank lines are ignored.
+func parseDockerignore(r io.Reader) ([]ignorePattern, error) {
+	patterns := make([]ignorePattern, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclusion := strings.HasPrefix(line, "!")
+		if exclusion {
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(line)), "/")
+		if line == "" {
+			continue
+		}
+		re, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid .dockerignore pattern '%s'.", line)
+		}
+		patterns = append(patterns, ignorePattern{re: re, exclusion: exclusion})
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// compileIgnorePattern returns the regular expression of the pattern
+// using the filepath.Match syntax extended with '**' to match any
+// number of directories.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				ch = pattern[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// isIgnored reports whether the slash separated name relative to the
+// build context is matched by the patterns. A pattern that matches a
+// parent directory matches its contents and the last matching pattern
+// takes precedence.
+func isIgnored(patterns []ignorePattern, name string) bool {
+	ignored := false
+	for _, p := range patterns {
+		if ignored != p.exclusion {
+			continue
+		}
+		if p.match(name) {
+			ignored = !p.exclusion
+		}
+	}
+	return ignored
+}
+
+func (p ignorePattern) match(name string) bool {
+	for {
+		if p.re.MatchString(name) {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i == -1 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+func hasExclusion(patterns []ignorePattern) bool {
+	for _, p := range patterns {
+		if p.exclusion {
+			return true
+		}
+	}
+	return false
+}
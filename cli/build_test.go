@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDockerignore(t *testing.T) {
+	patterns, err := parseDockerignore(strings.NewReader(`
+# comment
+node_modules
+*.log
+**/tmp
+docs/*.md
+!docs/README.md
+/build/
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := map[string]bool{
+		"node_modules":           true,
+		"node_modules/a/b.js":    true,
+		"src/node_modules":       false,
+		"debug.log":              true,
+		"src/debug.log":          false,
+		"tmp":                    true,
+		"a/b/tmp/c":              true,
+		"docs/guide.md":          true,
+		"docs/README.md":         false,
+		"docs/sub/guide.md":      false,
+		"build/out":              true,
+		"builder":                false,
+		"main.go":                false,
+		"# comment":              false,
+		"node_modules.txt":       false,
+		"src/tmp.go":             false,
+		"docs/README.md/ignored": false,
+	}
+	for name, want := range tests {
+		have := isIgnored(patterns, name)
+		if have != want {
+			t.Errorf("isIgnored(%q)\nhave %t\nwant %t", name, have, want)
+		}
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	tests := []struct {
+		image  string
+		deploy bool
+		want   string
+	}{
+		{"", false, "build -f Dockerfile -"},
+		{"app:v1", false, "build -f Dockerfile -t app:v1 -"},
+		{"app", true, "build -f Dockerfile -t app -"},
+		{"app:latest", true, "build -f Dockerfile -t app:latest -"},
+		{"app:v1", true, "build -f Dockerfile -t app:v1 -t app:latest -"},
+		{"localhost:5000/app:v1", true, "build -f Dockerfile -t localhost:5000/app:v1 -t localhost:5000/app:latest -"},
+	}
+	for _, tt := range tests {
+		have := strings.Join(buildArgs("Dockerfile", tt.image, tt.deploy), " ")
+		if have != tt.want {
+			t.Errorf("buildArgs %q deploy %t\nhave %s\nwant %s", tt.image, tt.deploy, have, tt.want)
+		}
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".dockerignore":     "*.log\nDockerfile\nsecret\n",
+		"Dockerfile":        "FROM scratch\n",
+		"app.go":            "package main\n",
+		"debug.log":         "log",
+		"secret/key":        "key",
+		"pkg/lib/lib.go":    "package lib\n",
+		"pkg/lib/trace.log": "log",
+	}
+	for name, data := range files {
+		filename := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0750)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = os.WriteFile(filename, []byte(data), 0640)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	outside := filepath.Join(t.TempDir(), "Dockerfile")
+	err := os.WriteFile(outside, []byte("FROM scratch\n"), 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		dockerfile string
+		name       string
+		want       []string
+	}{
+		{
+			filepath.Join(dir, "Dockerfile"),
+			"Dockerfile",
+			[]string{".dockerignore", "Dockerfile", "app.go", "pkg/", "pkg/lib/", "pkg/lib/lib.go", "pkg/lib/trace.log"},
+		},
+		{
+			outside,
+			"",
+			[]string{".abx.Dockerfile", ".dockerignore", "app.go", "pkg/", "pkg/lib/", "pkg/lib/lib.go", "pkg/lib/trace.log"},
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err = writeContext(&buf, dir, tt.dockerfile, tt.name, patterns)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		have := make([]string, 0)
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			have = append(have, hdr.Name)
+		}
+		sort.Strings(have)
+		if strings.Join(have, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("writeContext %s\nhave %v\nwant %v", tt.dockerfile, have, tt.want)
+		}
+	}
+}
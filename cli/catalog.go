@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pnelson/cli"
+)
+
+// catalogRegion represents a region machines can be created in.
+type catalogRegion struct {
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+}
+
+// catalogSize represents a machine size and the regions it is
+// available in. Memory and disk are in MB and GB respectively.
+type catalogSize struct {
+	Slug         string   `json:"slug"`
+	PriceMonthly float64  `json:"price_monthly"`
+	VCPUs        int      `json:"vcpus"`
+	Memory       int      `json:"memory"`
+	Disk         int      `json:"disk"`
+	Regions      []string `json:"regions"`
+	Available    bool     `json:"available"`
+}
+
+// fallbackRegions is the built-in region catalog used if
+// the service catalog cannot be retrieved.
+var fallbackRegions = []catalogRegion{
+	{"ams3", "Amsterdam 3", true},
+	{"blr1", "Bangalore 1", true},
+	{"fra1", "Frankfurt 1", true},
+	{"lon1", "London 1", true},
+	{"nyc1", "New York 1", true},
+	{"nyc3", "New York 3", true},
+	{"sfo3", "San Francisco 3", true},
+	{"sgp1", "Singapore 1", true},
+	{"syd1", "Sydney 1", true},
+	{"tor1", "Toronto 1", true},
+}
+
+// fallbackSizes is the built-in size catalog used if the
+// service catalog cannot be retrieved. Every size is
+// assumed to be available in every fallback region.
+var fallbackSizes = []catalogSize{
+	{"s-1vcpu-1gb", 6, 1, 1024, 25, nil, true},
+	{"s-1vcpu-1gb-intel", 7, 1, 1024, 25, nil, true},
+	{"s-1vcpu-2gb", 12, 1, 2048, 50, nil, true},
+	{"s-1vcpu-2gb-intel", 14, 1, 2048, 50, nil, true},
+	{"s-2vcpu-2gb", 18, 2, 2048, 60, nil, true},
+	{"s-2vcpu-2gb-intel", 21, 2, 2048, 60, nil, true},
+	{"s-2vcpu-4gb", 24, 2, 4096, 80, nil, true},
+	{"s-2vcpu-4gb-intel", 28, 2, 4096, 80, nil, true},
+	{"s-4vcpu-8gb", 48, 4, 8192, 160, nil, true},
+	{"s-4vcpu-8gb-intel", 56, 4, 8192, 160, nil, true},
+	{"s-8vcpu-16gb", 96, 8, 16384, 320, nil, true},
+	{"s-8vcpu-16gb-intel", 112, 8, 16384, 320, nil, true},
+}
+
+func (c *client) regions(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	regions, _, _ := c.catalog()
+	switch c.flags.catalog.format {
+	case "term":
+	case "json":
+		return json.NewEncoder(c.config.Stdout).Encode(regions)
+	default:
+		return fmt.Errorf("Format must be 'term' or 'json'.")
+	}
+	w := tabwriter.NewWriter(c.config.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SLUG\tNAME\tAVAILABLE\n")
+	for _, r := range regions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Slug, r.Name, formatAvailable(r.Available))
+	}
+	return w.Flush()
+}
+
+func (c *client) sizes(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	regions, sizes, _ := c.catalog()
+	region := c.flags.catalog.region
+	if region != "" {
+		_, err := findRegion(regions, region)
+		if err != nil {
+			return err
+		}
+		filtered := make([]catalogSize, 0, len(sizes))
+		for _, s := range sizes {
+			if s.availableIn(region) {
+				filtered = append(filtered, s)
+			}
+		}
+		sizes = filtered
+	}
+	switch c.flags.catalog.format {
+	case "term":
+	case "json":
+		return json.NewEncoder(c.config.Stdout).Encode(sizes)
+	default:
+		return fmt.Errorf("Format must be 'term' or 'json'.")
+	}
+	w := tabwriter.NewWriter(c.config.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SLUG\tPRICE\tVCPU\tMEMORY\tDISK\tREGIONS\n")
+	for _, s := range sizes {
+		regions := "all"
+		if !s.Available {
+			regions = "none"
+		} else if len(s.Regions) > 0 {
+			regions = strings.Join(s.Regions, ",")
+		}
+		fmt.Fprintf(w, "%s\t$%.2f/mo\t%d\t%s\t%dGB\t%s\n", s.Slug, s.PriceMonthly, s.VCPUs, formatMemory(s.Memory), s.Disk, regions)
+	}
+	return w.Flush()
+}
+
+// catalog returns the regions and sizes from the service, or the
+// built-in fallback catalog with a warning if the service is
+// unavailable. The live return value reports whether the catalog
+// came from the service.
+func (c *client) catalog() (regions []catalogRegion, sizes []catalogSize, live bool) {
+	regions, err := c.http.getRegions()
+	if err == nil {
+		sizes, err = c.http.getSizes()
+		if err == nil && len(regions) > 0 && len(sizes) > 0 {
+			return regions, sizes, true
+		}
+		if err == nil {
+			err = errors.New("catalog is empty")
+		}
+	}
+	c.verbose("Unable to retrieve the catalog: %v", err)
+	c.warn("Using the built-in catalog, which may be out of date.")
+	return fallbackRegions, fallbackSizes, false
+}
+
+// checkInit validates the region and size to initialize a machine
+// with against the catalog. The built-in catalog may be missing
+// slugs the service accepts, so a failed validation against it
+// is only a warning and the service has the final say.
+func (c *client) checkInit() error {
+	regions, sizes, live := c.catalog()
+	err := validateInit(regions, sizes, c.flags.init.Region, c.flags.init.Size)
+	if err != nil && !live {
+		c.warn("%v Continuing anyway.", err)
+		return nil
+	}
+	return err
+}
+
+// validateInit returns an error if the region or size to initialize
+// a machine with is not in the catalog, or not available together,
+// suggesting the closest match if there is one.
+func validateInit(regions []catalogRegion, sizes []catalogSize, region, size string) error {
+	r, err := findRegion(regions, region)
+	if err != nil {
+		return err
+	}
+	if !r.Available {
+		return fmt.Errorf("Region '%s' is not available for new machines.", region)
+	}
+	var s *catalogSize
+	slugs := make([]string, len(sizes))
+	for i := range sizes {
+		slugs[i] = sizes[i].Slug
+		if sizes[i].Slug == size {
+			s = &sizes[i]
+		}
+	}
+	if s == nil {
+		return fmt.Errorf("Size '%s' does not exist.%s Run 'abx sizes' to list them.", size, suggest(size, slugs))
+	}
+	if !s.availableIn(region) {
+		return fmt.Errorf("Size '%s' is not available in region '%s'. Run 'abx sizes -region %s' to list those that are.", size, region, region)
+	}
+	return nil
+}
+
+// findRegion returns the region in the catalog, or an error
+// suggesting the closest match if it does not exist.
+func findRegion(regions []catalogRegion, region string) (*catalogRegion, error) {
+	slugs := make([]string, len(regions))
+	for i := range regions {
+		if regions[i].Slug == region {
+			return &regions[i], nil
+		}
+		slugs[i] = regions[i].Slug
+	}
+	return nil, fmt.Errorf("Region '%s' does not exist.%s Run 'abx regions' to list them.", region, suggest(region, slugs))
+}
+
+// availableIn reports whether the size is available in the region.
+// An available size without regions is available in all of them.
+func (s catalogSize) availableIn(region string) bool {
+	if !s.Available {
+		return false
+	}
+	if len(s.Regions) == 0 {
+		return true
+	}
+	for _, r := range s.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns a sentence suggesting the candidate closest to s by
+// edit distance, if it is close enough to be a likely typo.
+func suggest(s string, candidates []string) string {
+	best := ""
+	min := len(s)/2 + 1
+	for _, candidate := range candidates {
+		d := levenshtein(s, candidate)
+		if d < min {
+			best = candidate
+			min = d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" Did you mean '%s'?", best)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	rv := values[0]
+	for _, v := range values[1:] {
+		if v < rv {
+			rv = v
+		}
+	}
+	return rv
+}
+
+func formatAvailable(available bool) string {
+	if available {
+		return "yes"
+	}
+	return "no"
+}
+
+// formatMemory returns memory in MB, or in GB if it is a whole number.
+func formatMemory(mb int) string {
+	if mb%1024 == 0 {
+		return fmt.Sprintf("%dGB", mb/1024)
+	}
+	return fmt.Sprintf("%dMB", mb)
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/cli"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"nyc1", "nyc1", 0},
+		{"nyc4", "nyc1", 1},
+		{"ncy1", "nyc1", 2},
+		{"kitten", "sitting", 3},
+		{"", "sfo3", 4},
+	}
+	for _, tt := range tests {
+		have := levenshtein(tt.a, tt.b)
+		if have != tt.want {
+			t.Errorf("levenshtein(%q, %q)\nhave %d\nwant %d", tt.a, tt.b, have, tt.want)
+		}
+	}
+}
+
+func TestValidateInit(t *testing.T) {
+	regions := []catalogRegion{
+		{"nyc1", "New York 1", true},
+		{"sfo3", "San Francisco 3", true},
+		{"ams2", "Amsterdam 2", false},
+	}
+	sizes := []catalogSize{
+		{"s-1vcpu-1gb-intel", 7, 1, 1024, 25, nil, true},
+		{"s-2vcpu-4gb", 24, 2, 4096, 80, []string{"sfo3"}, true},
+	}
+	tests := []struct {
+		region string
+		size   string
+		want   string
+	}{
+		{"nyc1", "s-1vcpu-1gb-intel", ""},
+		{"sfo3", "s-2vcpu-4gb", ""},
+		{"nyc3", "s-1vcpu-1gb-intel", "Did you mean 'nyc1'?"},
+		{"tokyo", "s-1vcpu-1gb-intel", "Region 'tokyo' does not exist. Run"},
+		{"ams2", "s-1vcpu-1gb-intel", "not available for new machines"},
+		{"nyc1", "s-1vcpu-1gb-intl", "Did you mean 's-1vcpu-1gb-intel'?"},
+		{"nyc1", "s-2vcpu-4gb", "not available in region 'nyc1'"},
+	}
+	for _, tt := range tests {
+		err := validateInit(regions, sizes, tt.region, tt.size)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("validateInit(%q, %q) unexpected error: %v", tt.region, tt.size, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validateInit(%q, %q)\nhave %v\nwant %q", tt.region, tt.size, err, tt.want)
+		}
+	}
+}
+
+func TestCheckInit(t *testing.T) {
+	live := true
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if !live {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch req.URL.Path {
+		case "/regions":
+			newTestHandler(t, http.StatusOK, fallbackRegions).ServeHTTP(w, req)
+		case "/sizes":
+			newTestHandler(t, http.StatusOK, fallbackSizes).ServeHTTP(w, req)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(fn))
+	defer ts.Close()
+	var stderr bytes.Buffer
+	c := &client{http: newService(ts.URL, "")}
+	c.cli = cli.New(AppName, nil, nil, cli.Stderr(&stderr))
+	c.flags.init.Region = "atl1"
+	c.flags.init.Size = "s-1vcpu-1gb"
+	err := c.checkInit()
+	if err == nil || !strings.Contains(err.Error(), "Region 'atl1' does not exist") {
+		t.Fatalf("live catalog should reject unknown region\nhave %v", err)
+	}
+	live = false
+	err = c.checkInit()
+	if err != nil {
+		t.Fatalf("built-in catalog should only warn\nhave %v", err)
+	}
+	if !strings.Contains(stderr.String(), "built-in catalog") || !strings.Contains(stderr.String(), "Region 'atl1' does not exist") {
+		t.Errorf("built-in catalog should warn\nhave %q", stderr.String())
+	}
+}
+
+func TestSizesRegion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	var stdout, stderr bytes.Buffer
+	c := &client{config: &Config{Stdout: &stdout}, http: newService(ts.URL, "")}
+	c.cli = cli.New(AppName, nil, nil, cli.Stderr(&stderr))
+	c.flags.catalog.format = "term"
+	c.flags.catalog.region = "nyc9"
+	err := c.sizes(nil)
+	if err == nil || !strings.Contains(err.Error(), "Did you mean 'nyc1'?") {
+		t.Fatalf("unknown region should be rejected with a suggestion\nhave %v", err)
+	}
+	if !strings.Contains(stderr.String(), "built-in catalog") {
+		t.Errorf("built-in catalog should warn without verbose\nhave %q", stderr.String())
+	}
+	c.flags.catalog.region = "nyc1"
+	err = c.sizes(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "s-1vcpu-1gb") {
+		t.Errorf("sizes in the region should be listed\nhave %q", stdout.String())
+	}
+}
@@ -2,13 +2,13 @@ package cli // import "acrobox.io/abx/cli"
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -18,8 +18,6 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/pnelson/cli"
-
-	"acrobox.io/docs"
 )
 
 // client represents the central manager of application activity.
@@ -28,6 +26,13 @@ type client struct {
 	cli    *cli.CLI
 	http   *service
 	flags  flags
+	conn   conn
+	locked bool          // holds the deploy lock
+	clock  clock         // for polling, defaults to the system clock
+	stdin  *bufio.Reader // buffers config.Stdin across prompts
+	// flagErrs holds invalid flag values of flag kinds that
+	// cannot fail to parse, see checkFlags.
+	flagErrs []error
 }
 
 // Config represents the core configuration parameters.
@@ -62,6 +67,7 @@ const (
 // program name, and dispatches to the appropriate handler.
 func Run(config *Config) error {
 	c := &client{config: config}
+	defer c.close()
 	options := []cli.Option{
 		cli.Scope("cli"),
 		cli.Prefix("ACROBOX"),
@@ -71,7 +77,7 @@ func Run(config *Config) error {
 		cli.Stdout(config.Stdout),
 		cli.Stderr(config.Stderr),
 	}
-	c.cli = cli.New(AppName, cli.NewUsageFS(docs.FS), []*cli.Flag{
+	c.cli = cli.New(AppName, cli.NewUsageFS(usageFS()), []*cli.Flag{
 		cli.NewFlag("host", &c.flags.host, cli.DefaultValue(username), cli.ShortFlag("h")),
 		cli.NewFlag("verbose", &c.flags.verbose, cli.Bool(), cli.ShortFlag("v")),
 		// Hidden
@@ -80,6 +86,10 @@ func Run(config *Config) error {
 	}, options...)
 	c.cli.Use(func(next cli.Handler) cli.Handler {
 		fn := func(args []string) error {
+			err := c.checkFlags()
+			if err != nil {
+				return err
+			}
 			c.http = newService(c.flags.addr, c.flags.auth)
 			return next(args)
 		}
@@ -93,6 +103,18 @@ func Run(config *Config) error {
 		cli.NewFlag("digitalocean-access-token", &c.flags.init.AccessToken, cli.EnvironmentKey("DIGITALOCEAN_ACCESS_TOKEN")),
 		cli.NewFlag("token", &c.flags.auth),
 		cli.NewFlag("force", &c.flags.init.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("resume", &c.flags.init.resume, cli.Bool()),
+		cli.NewFlag("rollback-on-failure", &c.flags.init.rollback, cli.Bool()),
+		cli.NewFlag("provision-timeout", &c.flags.init.provisionTimeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("10m")),
+		cli.NewFlag("ssh-timeout", &c.flags.init.sshTimeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("5m")),
+		cli.NewFlag("setup-timeout", &c.flags.init.setupTimeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("10m")),
+	})
+	c.cli.Add("regions", c.regions, []*cli.Flag{
+		cli.NewFlag("format", &c.flags.catalog.format, cli.DefaultValue("term"), cli.ShortFlag("f")),
+	})
+	c.cli.Add("sizes", c.sizes, []*cli.Flag{
+		cli.NewFlag("region", &c.flags.catalog.region, cli.ShortFlag("r")),
+		cli.NewFlag("format", &c.flags.catalog.format, cli.DefaultValue("term"), cli.ShortFlag("f")),
 	})
 	c.cli.Add("cancel", c.cancel, []*cli.Flag{
 		cli.NewFlag("token", &c.flags.auth),
@@ -102,11 +124,29 @@ func Run(config *Config) error {
 		cli.NewFlag("token", &c.flags.auth),
 		cli.NewFlag("force", &c.flags.renew.force, cli.Bool(), cli.ShortFlag("f")),
 	})
+	c.cli.Add("resize", c.resize, []*cli.Flag{
+		cli.NewFlag("size", &c.flags.resize.Size, cli.ShortFlag("s")),
+		cli.NewFlag("digitalocean-access-token", &c.flags.resize.AccessToken, cli.EnvironmentKey("DIGITALOCEAN_ACCESS_TOKEN")),
+		cli.NewFlag("token", &c.flags.auth),
+		cli.NewFlag("force", &c.flags.resize.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("timeout", &c.flags.resize.timeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("10m"), cli.ShortFlag("t")),
+	})
+	c.cli.Add("volume/resize", c.volumeResize, []*cli.Flag{
+		cli.NewFlag("data-size", &c.flags.volume.DataSize, cli.Kind(flagInt{}), cli.ShortFlag("d")),
+		cli.NewFlag("digitalocean-access-token", &c.flags.volume.AccessToken, cli.EnvironmentKey("DIGITALOCEAN_ACCESS_TOKEN")),
+		cli.NewFlag("token", &c.flags.auth),
+		cli.NewFlag("force", &c.flags.volume.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("timeout", &c.flags.volume.timeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("10m"), cli.ShortFlag("t")),
+	})
 	c.cli.Add("destroy", c.destroy, []*cli.Flag{
 		cli.NewFlag("digitalocean-access-token", &c.flags.destroy.AccessToken, cli.EnvironmentKey("DIGITALOCEAN_ACCESS_TOKEN")),
 		cli.NewFlag("token", &c.flags.auth),
 		cli.NewFlag("force", &c.flags.destroy.force, cli.Bool(), cli.ShortFlag("f")),
 	})
+	c.cli.Add("agent", c.agent, []*cli.Flag{
+		cli.NewFlag("timeout", &c.flags.agent.timeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("10m"), cli.ShortFlag("t")),
+		cli.NewFlag("detach", &c.flags.agent.detach, cli.Bool(), cli.ShortFlag("d")),
+	})
 	c.cli.Add("ssh", c.ssh, nil)
 	c.cli.Add("push", c.push, nil)
 	c.cli.Add("pull", c.pull, nil)
@@ -121,6 +161,7 @@ func Run(config *Config) error {
 	c.cli.Add("redis-cli", c.redisCLI, nil, cli.Proxy())
 	c.cli.Add("restore", c.restore, []*cli.Flag{
 		cli.NewFlag("force", &c.flags.restore.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
 	})
 	commands := []string{
 		"db/list",
@@ -136,6 +177,55 @@ func Run(config *Config) error {
 	}
 	// Containers
 	c.cli.Add("deploy", c.deploy, nil, cli.Proxy())
+	c.cli.Add("build", c.build, []*cli.Flag{
+		cli.NewFlag("tag", &c.flags.build.tag, cli.ShortFlag("t")),
+		cli.NewFlag("file", &c.flags.build.file, cli.ShortFlag("f")),
+		cli.NewFlag("deploy", &c.flags.build.deploy, cli.Bool()),
+		cli.NewFlag("keep", &c.flags.deploy.keep, cli.Kind(flagInt{}), cli.DefaultValue("10")),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("deploys", c.deploys, []*cli.Flag{
+		cli.NewFlag("format", &c.flags.deploys.format, cli.DefaultValue("term"), cli.ShortFlag("f")),
+	})
+	c.cli.Add("rollback", c.rollback, []*cli.Flag{
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("promote", c.promote, []*cli.Flag{
+		cli.NewFlag("from", &c.flags.promote.from),
+		cli.NewFlag("to", &c.flags.promote.to),
+		cli.NewFlag("digest", &c.flags.promote.digest),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("plan", c.plan, []*cli.Flag{
+		cli.NewFlag("file", &c.flags.manifest.file, cli.DefaultValue("abx.json"), cli.ShortFlag("f")),
+		cli.NewFlag("prune", &c.flags.manifest.prune, cli.Bool()),
+	})
+	c.cli.Add("apply", c.apply, []*cli.Flag{
+		cli.NewFlag("file", &c.flags.manifest.file, cli.DefaultValue("abx.json")),
+		cli.NewFlag("prune", &c.flags.manifest.prune, cli.Bool()),
+		cli.NewFlag("force", &c.flags.manifest.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("config/export", c.configExport, []*cli.Flag{
+		cli.NewFlag("encrypt", &c.flags.config.encrypt, cli.Bool(), cli.ShortFlag("e")),
+	})
+	c.cli.Add("config/import", c.configImport, []*cli.Flag{
+		cli.NewFlag("dry-run", &c.flags.config.dryRun, cli.Bool(), cli.ShortFlag("n")),
+		cli.NewFlag("force", &c.flags.config.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("migrate", c.migrate, []*cli.Flag{
+		cli.NewFlag("from", &c.flags.migrate.from),
+		cli.NewFlag("to", &c.flags.migrate.to),
+		cli.NewFlag("force", &c.flags.migrate.force, cli.Bool(), cli.ShortFlag("f")),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	c.cli.Add("lock/break", c.lockBreak, []*cli.Flag{
+		cli.NewFlag("force", &c.flags.lock.force, cli.Bool(), cli.ShortFlag("f")),
+	})
+	c.cli.Add("release/set", c.releaseSet, nil, cli.Proxy())
+	c.cli.Add("release/get", c.releaseGet, nil)
+	c.cli.Add("release/del", c.releaseDel, nil)
 	c.cli.Add("logs", c.logs, nil, cli.Proxy())
 	c.cli.Add("exec", c.exec, nil)
 	commands = []string{
@@ -170,105 +260,211 @@ func (c *client) init(args []string) error {
 	if len(args) > 0 {
 		return cli.ErrUsage
 	}
-	ipv4 := filepath.Join(c.config.Home, c.flags.host, "IPv4")
-	_, err := os.Stat(ipv4)
-	if !os.IsNotExist(err) {
-		dir := filepath.Dir(ipv4)
-		return fmt.Errorf("Machine '%s' already exists.", dir)
-	}
-	c.step(colorINF, "Creating a new key pair.")
-	privateKey, authorizedKey, err := newKeyPair()
+	dir := filepath.Join(c.config.Home, c.flags.host)
+	state, err := c.readInitState()
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	c.flags.init.Product = "Indie Hacker"
-	c.flags.init.Name = c.flags.host
-	c.flags.init.PublicKey = string(authorizedKey)
-	if !c.flags.init.force {
-		c.step(colorWRN, "Payment authorization required.")
-		c.cli.Printf(cardAuthText)
-		name := c.cli.Prompt("\033[1;%dm•\033[0m \033[1;37mPlease type '%s' to agree:\033[0m ", colorWRN, c.flags.host)
-		if name != c.flags.host {
-			c.step(colorERR, "Input must be '%s' to agree.", c.flags.host)
+	if state == nil {
+		if c.flags.init.resume {
+			return fmt.Errorf("Machine '%s' has no initialization to resume.", dir)
+		}
+		_, err = os.Stat(filepath.Join(dir, "IPv4"))
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Machine '%s' already exists.", dir)
+		}
+		err = c.createMachine()
+		if err != nil {
+			return err
+		}
+		state, err = c.readInitState()
+		if err != nil {
+			c.step(colorERR, "%v", err)
 			return cli.ErrExitFailure
 		}
+	} else {
+		c.step(colorINF, "Resuming initialization of machine '%s'.", state.ID)
 	}
-	c.step(colorINF, "Initializing with '%s'.", c.flags.addr)
-	id, err := c.http.initMachine(c.flags.init)
-	if err != nil {
-		verr, ok := err.(errorResponse)
+	ctx, stop := interruptContext()
+	defer stop()
+	for _, step := range c.initSteps(ctx, state.ID) {
+		_, ok := state.Done[step.name]
 		if ok {
-			c.step(colorERR, verr.Message)
-		} else {
+			c.verbose("Skipping %s, completed in a previous run.", step.name)
+			continue
+		}
+		err = step.fn()
+		if err != nil {
 			c.step(colorERR, "%v", err)
+			if c.flags.init.rollback {
+				return c.rollbackInit(state)
+			}
+			c.cli.Errorf("Run 'abx init -resume' to continue from this step.\n")
+			return cli.ErrExitFailure
+		}
+		state.Done[step.name] = time.Now().UTC()
+		err = c.writeInitState(state)
+		if err != nil {
+			c.step(colorERR, "%v", err)
+			return cli.ErrExitFailure
 		}
-		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Provisioning machine and associated resources.")
-	m, err := c.waitForMachine(id)
+	err = os.Remove(filepath.Join(dir, initStateFile))
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Writing machine configuration.")
-	dir := filepath.Join(c.config.Home, c.flags.host)
-	err = os.MkdirAll(dir, 0770)
+	c.step(colorINF, "Acrobox is ready.")
+	return nil
+}
+
+// createMachine authorizes payment and requests a new machine. The key
+// pair, machine ID, and provisioning state are written to the machine
+// directory as soon as they are known such that an interrupted init
+// can be resumed without creating another machine.
+func (c *client) createMachine() error {
+	err := c.checkInit()
 	if err != nil {
-		c.step(colorERR, "Data directory '%s' does not exist or cannot be created.", dir)
+		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	err = c.writeString("ID", m.ID)
+	c.step(colorINF, "Creating a new key pair.")
+	privateKey, authorizedKey, err := newKeyPair()
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	err = c.writeString("IPv4", m.IPv4)
+	c.flags.init.Product = "Indie Hacker"
+	c.flags.init.Name = c.flags.host
+	c.flags.init.PublicKey = string(authorizedKey)
+	if !c.flags.init.force {
+		c.step(colorWRN, "Payment authorization required.")
+		c.cli.Printf(cardAuthText)
+		name := c.prompt("\033[1;%dm•\033[0m \033[1;37mPlease type '%s' to agree:\033[0m ", colorWRN, c.flags.host)
+		if name != c.flags.host {
+			c.step(colorERR, "Input must be '%s' to agree.", c.flags.host)
+			return cli.ErrExitFailure
+		}
+	}
+	dir := filepath.Join(c.config.Home, c.flags.host)
+	err = os.MkdirAll(dir, 0770)
 	if err != nil {
-		c.step(colorERR, "%v", err)
+		c.step(colorERR, "Data directory '%s' does not exist or cannot be created.", dir)
 		return cli.ErrExitFailure
 	}
-	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(m.PublicKey))
+	err = c.writeKey("id_ed25519", privateKey)
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	knownHosts := knownhosts.Line([]string{m.IPv4 + ":" + c.flags.port}, pub)
-	err = c.writeString("known_hosts", knownHosts)
+	err = c.writeKey("id_ed25519.pub", authorizedKey)
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	err = c.writeKey("id_ed25519", privateKey)
+	c.step(colorINF, "Initializing with '%s'.", c.flags.addr)
+	id, err := c.http.initMachine(c.flags.init)
 	if err != nil {
-		c.step(colorERR, "%v", err)
+		verr, ok := err.(errorResponse)
+		if ok {
+			c.step(colorERR, verr.Message)
+		} else {
+			c.step(colorERR, "%v", err)
+		}
 		return cli.ErrExitFailure
 	}
-	err = c.writeKey("id_ed25519.pub", authorizedKey)
+	err = c.writeString("ID", id)
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Waiting for SSH connectivity.")
-	err = c.waitForSSH(m.IPv4)
+	err = c.writeInitState(&initState{ID: id, Done: make(map[string]time.Time)})
 	if err != nil {
 		c.step(colorERR, "%v", err)
 		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Waiting for machine setup.")
-	err = c.waitForAcrobox()
+	return nil
+}
+
+// rollbackInit destroys the machine of a failed init, along with its
+// droplet and volume, and removes the machine directory. If teardown
+// fails, the machine directory is kept and the failure is recorded in
+// the provisioning state such that 'abx destroy' can finish the job.
+func (c *client) rollbackInit(state *initState) error {
+	dir := filepath.Join(c.config.Home, c.flags.host)
+	c.step(colorWRN, "Rolling back machine '%s'.", state.ID)
+	err := c.http.destroyMachine(state.ID, flagsDestroy{AccessToken: c.flags.init.AccessToken})
 	if err != nil {
-		c.step(colorERR, "%v", err)
+		c.step(colorERR, "Unable to destroy machine '%s': %v", state.ID, err)
+		c.cli.Printf("The following resources were not torn down and may still be billed:\n")
+		c.cli.Printf("  Machine '%s' with its droplet and volume.\n", state.ID)
+		c.cli.Printf("  Machine directory '%s'.\n", dir)
+		state.RollbackError = err.Error()
+		err = c.writeInitState(state)
+		if err != nil {
+			c.step(colorERR, "%v", err)
+			return cli.ErrExitFailure
+		}
+		c.cli.Printf("Run 'abx destroy' to tear them down.\n")
 		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Waiting for service setup.")
-	err = c.waitForService()
+	c.step(colorINF, "Destroyed machine '%s' with its droplet and volume.", state.ID)
+	err = os.RemoveAll(dir)
 	if err != nil {
-		c.step(colorERR, "%v", err)
+		c.step(colorERR, "Unable to remove machine directory '%s': %v", dir, err)
 		return cli.ErrExitFailure
 	}
-	c.step(colorINF, "Acrobox is ready.")
-	return nil
+	c.step(colorINF, "Removed machine directory '%s'.", dir)
+	return cli.ErrExitFailure
+}
+
+// initSteps returns the steps to bring the requested machine up.
+func (c *client) initSteps(ctx context.Context, id string) []resumableStep {
+	var m *getMachineResponse
+	return []resumableStep{
+		{initProvisioned, func() error {
+			c.step(colorINF, "Provisioning machine and associated resources.")
+			var err error
+			m, err = c.waitForMachine(ctx, id, c.flags.init.provisionTimeout)
+			if err != nil {
+				return err
+			}
+			return c.writeString("IPv4", m.IPv4)
+		}},
+		{initKnownHosts, func() error {
+			c.step(colorINF, "Writing machine configuration.")
+			if m == nil {
+				var err error
+				m, err = c.http.getMachine(id)
+				if err != nil {
+					return err
+				}
+			}
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(m.PublicKey))
+			if err != nil {
+				return err
+			}
+			knownHosts := knownhosts.Line([]string{m.IPv4 + ":" + c.flags.port}, pub)
+			return c.writeString("known_hosts", knownHosts)
+		}},
+		{initSSH, func() error {
+			c.step(colorINF, "Waiting for SSH connectivity.")
+			ipv4, err := c.getIPv4()
+			if err != nil {
+				return err
+			}
+			return c.waitForSSH(ctx, ipv4, c.flags.init.sshTimeout)
+		}},
+		{initAcrobox, func() error {
+			c.step(colorINF, "Waiting for machine setup.")
+			return c.waitForAcrobox(ctx, c.flags.init.setupTimeout)
+		}},
+		{initService, func() error {
+			c.step(colorINF, "Waiting for service setup.")
+			return c.waitForService(ctx, c.flags.init.setupTimeout)
+		}},
+	}
 }
 
 func (c *client) cancel(args []string) error {
@@ -390,14 +586,15 @@ func (c *client) push(args []string) error {
 	}
 	target := args[len(args)-1]
 	source := args[:len(args)-1]
-	fileType, _, err := c.run("stat", "-L", "-c", "%F", target)
+	sc, err := c.sftpClient()
 	if err != nil {
-		serr, ok := err.(*ssh.ExitError)
-		if !ok || serr.ExitStatus() != 1 {
-			return err
-		}
+		return err
 	}
-	targetIsDir := string(fileType) == "directory\n"
+	fi, err := sc.Stat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	targetIsDir := err == nil && fi.IsDir()
 	if targetIsDir {
 		for _, s := range source {
 			err = c.pushOne(s, target, true)
@@ -429,36 +626,27 @@ func (c *client) pushOne(source, target string, targetIsDir bool) error {
 	}
 	filename := target
 	if targetIsDir {
-		filename = filepath.Join(target, fi.Name())
+		filename = path.Join(target, fi.Name())
 	}
-	_, _, err = c.runWithStdin(f, "sh", "-c", "cat > "+filename)
-	return err
+	err = c.upload(f, filename, fi.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Unable to push '%s' to '%s': %v", source, filename, err)
+	}
+	return nil
 }
 
+// pushDir copies the source directory into the target directory
+// as a single tar stream.
 func (c *client) pushDir(source, target string) error {
-	dir := filepath.Dir(source)
-	fn := func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		name := strings.TrimPrefix(path, dir)
-		filename := filepath.Join(target, name)
-		if fi.IsDir() {
-			if filename == target {
-				return nil
-			}
-			_, _, err = c.run("mkdir", filename)
-			return err
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		_, _, err = c.runWithStdin(f, "sh", "-c", "cat > "+filename)
-		return err
-	}
-	return filepath.Walk(source, fn)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, source))
+	}()
+	p := c.newProgress(filepath.Base(source), 0, 0)
+	_, _, err := c.runWithStdin(p.reader(pr), "tar", "-x", "-C", target, "-f", "-")
+	p.Close()
+	pr.CloseWithError(err)
+	return err
 }
 
 func (c *client) pull(args []string) error {
@@ -487,47 +675,47 @@ func (c *client) pull(args []string) error {
 }
 
 func (c *client) pullOne(source, target string) error {
-	fileType, _, err := c.run("stat", "-L", "-c", "%F", source)
+	sc, err := c.sftpClient()
 	if err != nil {
-		serr, ok := err.(*ssh.ExitError)
-		if !ok || serr.ExitStatus() != 1 {
-			return err
-		}
+		return err
 	}
-	target = filepath.Join(target, filepath.Base(source))
-	sourceIsDir := string(fileType) == "directory\n"
-	if sourceIsDir {
+	fi, err := sc.Stat(source)
+	if err != nil {
+		return fmt.Errorf("Unable to pull '%s': %v", source, err)
+	}
+	target = filepath.Join(target, path.Base(source))
+	if fi.IsDir() {
 		source = strings.TrimSuffix(source, "/")
 		return c.pullDir(source, target)
 	}
-	b, _, err := c.run("cat", source)
+	err = c.pullFile(sc, source, target)
 	if err != nil {
-		return err
+		return fmt.Errorf("Unable to pull '%s' to '%s': %v", source, target, err)
 	}
-	return os.WriteFile(target, b, 0640)
+	return nil
 }
 
+// pullDir copies the source directory to the target path
+// as a single tar stream. Entries outside of the source
+// directory are rejected.
 func (c *client) pullDir(source, target string) error {
-	b, _, err := c.run("find", source)
-	if err != nil {
-		return err
-	}
-	err = os.MkdirAll(target, 0750)
+	dir := filepath.Dir(target)
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	p := c.newProgress(path.Base(source), 0, 0)
+	defer p.Close()
+	go func() {
+		_, err := c.stream(nil, p.writer(pw), "tar", "-c", "-C", path.Dir(source), "-f", "-", path.Base(source))
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+	err := extractTar(pr, dir, path.Base(source))
+	pr.CloseWithError(err)
+	serr := <-errc
 	if err != nil {
 		return err
 	}
-	s := bufio.NewScanner(bytes.NewReader(b))
-	for s.Scan() {
-		filename := s.Text()
-		if filename == source {
-			continue
-		}
-		err = c.pullOne(filename, target)
-		if err != nil {
-			return err
-		}
-	}
-	return s.Err()
+	return serr
 }
 
 func (c *client) status(args []string) error {
@@ -701,6 +889,11 @@ func (c *client) restore(args []string) error {
 			return err
 		}
 	}
+	unlock, err := c.lock("restore")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	args = append([]string{"exec", "acroboxd", "acroboxd", "restore"}, args...)
 	stdout, stderr, err := c.run("docker", args...)
 	if err != nil {
@@ -717,35 +910,54 @@ func (c *client) restore(args []string) error {
 }
 
 func (c *client) deploy(args []string) error {
-	if len(args) < 1 {
-		return cli.ErrUsage
+	args, err := parseProxyFlags(args, []*cli.Flag{
+		cli.NewFlag("compress", &c.flags.deploy.compress, cli.DefaultValue("none"), cli.ShortFlag("z")),
+		cli.NewFlag("registry", &c.flags.deploy.registry),
+		cli.NewFlag("skip-platform-check", &c.flags.deploy.skipPlatformCheck, cli.Bool()),
+		cli.NewFlag("keep", &c.flags.deploy.keep, cli.Kind(flagInt{}), cli.DefaultValue("10")),
+		cli.NewFlag("health-path", &c.flags.deploy.healthPath),
+		cli.NewFlag("health-timeout", &c.flags.deploy.healthTimeout, cli.Kind(flagDuration{&c.flagErrs}), cli.DefaultValue("60s")),
+		cli.NewFlag("health-rollback", &c.flags.deploy.healthRollback, cli.Bool()),
+		cli.NewFlag("release", &c.flags.deploy.release),
+		cli.NewFlag("wait", &c.flags.lock.wait, cli.Bool()),
+	})
+	if err != nil {
+		return err
 	}
-	image := imageName(args[len(args)-1])
-	f, err := os.CreateTemp("", "abx-deploy-")
+	err = c.checkFlags()
 	if err != nil {
 		return err
 	}
-	local := f.Name()
-	defer os.Remove(local)
-	cmd := exec.Command("docker", "save", image, "-o", local)
-	cmd.Stdout = c.config.Stdout
-	cmd.Stderr = c.config.Stderr
-	err = cmd.Run()
+	if len(args) < 1 {
+		return cli.ErrUsage
+	}
+	unlock, err := c.lock("deploy " + imageName(args[len(args)-1]))
 	if err != nil {
 		return err
 	}
-	err = c.pushOne(local, "/tmp/", true)
+	defer unlock()
+	ref := args[len(args)-1]
+	image := imageName(ref)
+	if c.flags.deploy.registry != "" {
+		ref = c.flags.deploy.registry + "/" + ref
+	}
+	if registryHost(ref) != "" {
+		err = c.pullImage(ref, image)
+	} else {
+		err = c.checkPlatform(image)
+		if err == nil {
+			err = c.load(image)
+		}
+	}
 	if err != nil {
 		return err
 	}
-	base := filepath.Base(local)
-	remote := filepath.Join("/tmp", base)
-	_, stderr, err := c.run("sh", "-c", "docker load -i "+remote+" && rm "+remote)
+	args[len(args)-1] = image
+	err = c.release(image, args)
 	if err != nil {
-		c.cli.Errorf("%s\n", stderr)
 		return err
 	}
-	return c.acroboxd("deploy", args)
+	return c.healthGate(image)
 }
 
 func (c *client) logs(args []string) error {
@@ -761,19 +973,64 @@ func (c *client) exec(args []string) error {
 	return c.runWithOutput("docker", args...)
 }
 
+// checkFlags returns a usage error if any flag value is invalid.
+func (c *client) checkFlags() error {
+	if len(c.flagErrs) == 0 {
+		return nil
+	}
+	c.cli.Errorf("%v\n", c.flagErrs[0])
+	return cli.ErrUsage
+}
+
 func (c *client) step(level int, format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
 	c.cli.Printf("\033[1;%dm•\033[0m \033[1;37m%s\033[0m\n", level, message)
 }
 
+// verbose writes a step to stderr if verbose output is enabled.
+func (c *client) verbose(format string, args ...interface{}) {
+	if !c.flags.verbose {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	c.cli.Errorf("\033[1;%dm•\033[0m \033[1;37m%s\033[0m\n", colorINF, message)
+}
+
+// warn writes a warning step to stderr regardless of verbosity.
+func (c *client) warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	c.cli.Errorf("\033[1;%dm•\033[0m \033[1;37m%s\033[0m\n", colorWRN, message)
+}
+
 func (c *client) promptToAgree() error {
-	name := c.cli.Prompt("Please type '%s' to agree: ", c.flags.host)
+	name := c.prompt("Please type '%s' to agree: ", c.flags.host)
 	if name != c.flags.host {
 		return fmt.Errorf("Input must be '%s' to agree.", c.flags.host)
 	}
 	return nil
 }
 
+// prompt writes the prompt to stdout and returns the next line of stdin.
+func (c *client) prompt(format string, args ...interface{}) string {
+	c.cli.Printf(format, args...)
+	line, _ := c.readLine()
+	return line
+}
+
+// readLine returns the next line of stdin without the line ending.
+// Stdin is buffered across calls such that input read ahead by one
+// prompt remains available to the next.
+func (c *client) readLine() (string, error) {
+	if c.stdin == nil {
+		c.stdin = bufio.NewReader(c.config.Stdin)
+	}
+	line, err := c.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
 func (c *client) resolver(err error) {
 	switch e := err.(type) {
 	case *fs.PathError:
@@ -812,11 +1069,13 @@ func roundDuration(d time.Duration) time.Duration {
 }
 
 func imageName(s string) string {
-	for _, c := range []string{":", "@"} {
-		i := strings.Index(s, c)
-		if i != -1 {
-			s = s[:i]
-		}
+	i := strings.Index(s, "@")
+	if i != -1 {
+		s = s[:i]
+	}
+	i = strings.LastIndex(s, ":")
+	if i != -1 && !strings.Contains(s[i:], "/") {
+		s = s[:i]
 	}
 	return s
 }
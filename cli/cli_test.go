@@ -1,16 +1,21 @@
 package cli
 
 import (
+	"archive/tar"
+	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 
 	"github.com/pnelson/cli"
 )
@@ -29,9 +34,9 @@ func TestInit(t *testing.T) {
 	ts := httptest.NewServer(fn)
 	defer ts.Close()
 	home := t.TempDir()
-	port := newTestSSH(t, home, privateHostKey)
+	ss := newTestSSH(t, home, privateHostKey)
 	config := &Config{
-		Args:   []string{"abx", "-addr", ts.URL, "-port", port, "init", "-force"},
+		Args:   []string{"abx", "-addr", ts.URL, "-port", ss.port, "init", "-force"},
 		Home:   home,
 		Stdout: io.Discard,
 		Stderr: io.Discard,
@@ -45,7 +50,7 @@ func TestInit(t *testing.T) {
 	if os.IsNotExist(err) {
 		t.Fatalf("host data directory '%s' should exist", dir)
 	}
-	filenames := []string{"IPv4", "known_hosts", "id_ed25519", "id_ed25519.pub"}
+	filenames := []string{"ID", "IPv4", "known_hosts", "id_ed25519", "id_ed25519.pub"}
 	for _, name := range filenames {
 		filename := filepath.Join(dir, name)
 		_, err = os.Stat(filename)
@@ -77,6 +82,405 @@ func TestInitErr(t *testing.T) {
 	}
 }
 
+func TestInitResume(t *testing.T) {
+	privateHostKey, publicHostKey := newTestHostKeyPair(t)
+	view := getMachineResponse{
+		ID:        "test",
+		IPv4:      "127.0.0.1",
+		PublicKey: publicHostKey,
+	}
+	var created int32
+	handler := newTestHandler(t, http.StatusOK, view)
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			atomic.AddInt32(&created, 1)
+		}
+		handler.ServeHTTP(w, req)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(fn))
+	defer ts.Close()
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	config := &Config{
+		Args:   []string{"abx", "-addr", ts.URL, "-port", ss.port, "init", "-resume"},
+		Home:   home,
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	}
+	err := Run(config)
+	if err == nil {
+		t.Fatalf("resume without provisioning state should fail")
+	}
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	err = os.Remove(filepath.Join(home, username, "known_hosts"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state := &initState{ID: "test", Done: map[string]time.Time{initProvisioned: time.Now().UTC()}}
+	err = c.writeInitState(state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = Run(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&created) != 0 {
+		t.Fatalf("resume should not create another machine")
+	}
+	_, err = os.Stat(filepath.Join(home, username, "known_hosts"))
+	if err != nil {
+		t.Fatalf("known_hosts should be written on resume: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(home, username, initStateFile))
+	if !os.IsNotExist(err) {
+		t.Fatalf("provisioning state should be removed on completion")
+	}
+}
+
+func TestInitRollback(t *testing.T) {
+	tests := []struct {
+		code   int
+		exists bool
+	}{
+		{http.StatusNoContent, false},
+		{http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		var destroyed int32
+		fn := func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodDelete {
+				atomic.AddInt32(&destroyed, 1)
+				w.WriteHeader(tt.code)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(fn))
+		home := t.TempDir()
+		privateHostKey, _ := newTestHostKeyPair(t)
+		c := newTestClient(t, home, "22", privateHostKey)
+		state := &initState{ID: "test", Done: map[string]time.Time{initProvisioned: time.Now().UTC()}}
+		err := c.writeInitState(state)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		config := &Config{
+			Args:   []string{"abx", "-addr", ts.URL, "init", "-rollback-on-failure"},
+			Home:   home,
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+		}
+		err = Run(config)
+		ts.Close()
+		if err != cli.ErrExitFailure {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if atomic.LoadInt32(&destroyed) != 1 {
+			t.Fatalf("machine should be destroyed once")
+		}
+		_, err = os.Stat(filepath.Join(home, username))
+		if !os.IsNotExist(err) != tt.exists {
+			t.Fatalf("machine directory exists should be %t", tt.exists)
+		}
+		if !tt.exists {
+			continue
+		}
+		state, err = c.readInitState()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if state.RollbackError == "" {
+			t.Errorf("failed rollback should be recorded in the provisioning state")
+		}
+	}
+}
+
+func TestSessionReuse(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	for i := 0; i < 3; i++ {
+		_, _, err := c.run("docker exec acroboxd acroboxd status")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	have := atomic.LoadInt32(&ss.conns)
+	if have != 1 {
+		t.Fatalf("connections\nhave %d\nwant %d", have, 1)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	c.conn.direct = true
+	a, err := newAgent(c, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := listenAgent(c.agentSocket())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(filepath.Dir(c.agentSocket()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("agent directory should only be accessible to its owner\nhave %v", fi.Mode().Perm())
+	}
+	go a.serve(l)
+	for i := 0; i < 3; i++ {
+		p := &client{config: c.config, flags: c.flags}
+		_, _, err = p.run("docker exec acroboxd acroboxd status")
+		p.close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	have := atomic.LoadInt32(&ss.conns)
+	if have != 1 {
+		t.Fatalf("connections\nhave %d\nwant %d", have, 1)
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	name := "it's a file.txt"
+	want := []byte("test")
+	local := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(local, want, 0604)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote := t.TempDir()
+	err = c.push([]string{local, remote})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	err = c.pull([]string{filepath.Join(remote, name), dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filename := filepath.Join(dir, name)
+	have, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(have) != string(want) {
+		t.Fatalf("pull\nhave '%s'\nwant '%s'", have, want)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0604 {
+		t.Fatalf("mode\nhave %s\nwant %s", fi.Mode().Perm(), os.FileMode(0604))
+	}
+	err = c.pull([]string{filepath.Join(remote, "missing"), dir})
+	if err == nil {
+		t.Fatalf("pull of missing file should fail")
+	}
+}
+
+func TestPullStream(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	var stdout bytes.Buffer
+	c.config.Stdout = &stdout
+	c.flags.verbose = true
+	want := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	remote := filepath.Join(t.TempDir(), "large.bin")
+	err := os.WriteFile(remote, want, 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "large.bin")
+	err = os.WriteFile(filename, []byte("old"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.pull([]string{remote, dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Fatalf("pull should replace the target with %d bytes\nhave %d bytes", len(want), len(have))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files should be removed\nhave %v", entries)
+	}
+	if !strings.Contains(stdout.String(), "large.bin") {
+		t.Errorf("progress should be reported\nhave %q", stdout.String())
+	}
+}
+
+func TestPullDirHostile(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	remote := filepath.Join(t.TempDir(), "data")
+	err := os.Mkdir(remote, 0750)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archive := filepath.Join(home, "hostile.tar")
+	hdrs := []*tar.Header{{Name: "other/evil", Typeflag: tar.TypeReg, Size: 5}}
+	b, err := io.ReadAll(newTestTar(t, hdrs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = os.WriteFile(archive, b, 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ss.fake(t, "tar", "cat '"+archive+"'")
+	dir := t.TempDir()
+	err = c.pull([]string{remote, dir})
+	if err == nil {
+		t.Fatalf("pull of entries outside of the source directory should fail")
+	}
+	_, err = os.Stat(filepath.Join(dir, "other"))
+	if !os.IsNotExist(err) {
+		t.Errorf("entries outside of the source directory should not be extracted\nhave %v", err)
+	}
+}
+
+func TestPushResume(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	want := []byte("resumable upload")
+	local := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(local, want, 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote := filepath.Join(t.TempDir(), "file")
+	err = os.WriteFile(remote+".partial", want[:9], 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.push([]string{local, remote})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := os.ReadFile(remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(have) != string(want) {
+		t.Fatalf("push\nhave '%s'\nwant '%s'", have, want)
+	}
+	_, err = os.Stat(remote + ".partial")
+	if !os.IsNotExist(err) {
+		t.Fatalf("partial file should not exist")
+	}
+	err = os.WriteFile(remote+".partial", []byte("corrupt"), 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.push([]string{local, remote})
+	if err == nil {
+		t.Fatalf("push with corrupt partial file should fail")
+	}
+}
+
+func TestLock(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	other := &client{config: c.config, flags: c.flags}
+	defer other.close()
+	unlock, err := c.lock("deploy web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nested, err := c.lock("rollback web")
+	if err != nil {
+		t.Fatalf("nested lock should not fail: %v", err)
+	}
+	nested()
+	_, err = other.lock("deploy web")
+	if err == nil || !strings.Contains(err.Error(), "(deploy web)") {
+		t.Fatalf("lock should be busy and report the holder\nhave %v", err)
+	}
+	c.close()
+	_, _, err = c.run("docker exec acroboxd acroboxd status")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = other.lock("deploy web")
+	if err == nil {
+		t.Fatalf("lock should be held when the cached connection is redialed")
+	}
+	unlock()
+	unlock, err = other.lock("deploy web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlock()
+}
+
+func newTestClient(t *testing.T, home, port string, privateHostKey ssh.Signer) *client {
+	t.Helper()
+	c := &client{config: &Config{Home: home, Stdout: io.Discard, Stderr: io.Discard}}
+	c.flags.host = username
+	c.flags.port = port
+	err := os.MkdirAll(filepath.Join(home, username), 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	privateKey, authorizedKey, err := newKeyPair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	knownHosts := knownhosts.Line([]string{"127.0.0.1:" + port}, privateHostKey.PublicKey())
+	files := map[string]string{
+		"IPv4":           "127.0.0.1",
+		"known_hosts":    knownHosts,
+		"id_ed25519":     string(privateKey),
+		"id_ed25519.pub": string(authorizedKey),
+	}
+	for name, value := range files {
+		err = c.writeBytes(name, []byte(value+"\n"), 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return c
+}
+
 func newTestHostKeyPair(t *testing.T) (ssh.Signer, string) {
 	t.Helper()
 	privateKey, authorizedKey, err := newKeyPair()
@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// errStreamDone is used to unblock the local end of an image
+// stream after the remote end has stopped reading.
+var errStreamDone = errors.New("stream done")
+
+// load streams the image from docker save on the local machine
+// directly into docker load on the machine, compressing the stream
+// in transit if compression is enabled.
+//
+// If the machine already has a previous deploy of the image then
+// only the layers it does not have are sent.
+func (c *client) load(image string) error {
+	decompress, err := decompressCommand(c.flags.deploy.compress)
+	if err != nil {
+		return err
+	}
+	layers, err := c.remoteLayers(image)
+	if err != nil || len(layers) == 0 {
+		return c.loadSave(image, decompress)
+	}
+	return c.loadIncremental(image, layers, decompress)
+}
+
+// loadSave streams docker save directly into docker load
+// without temporary files on either end.
+func (c *client) loadSave(image, decompress string) error {
+	size, err := imageSize(image)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("docker", "save", image)
+	cmd.Stderr = c.config.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	stderr, rerr := c.loadStream(stdout, image, size, decompress)
+	stdout.Close()
+	err = cmd.Wait()
+	if rerr != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return rerr
+	}
+	if err != nil {
+		return fmt.Errorf("docker save %s: %v", image, err)
+	}
+	return nil
+}
+
+// loadIncremental saves the image to a local temporary file and
+// streams it into docker load without the layers already on the
+// machine. The full image is sent if the incremental load fails.
+func (c *client) loadIncremental(image string, layers []string, decompress string) error {
+	f, err := os.CreateTemp("", "abx-deploy-")
+	if err != nil {
+		return err
+	}
+	filename := f.Name()
+	f.Close()
+	defer os.Remove(filename)
+	cmd := exec.Command("docker", "save", "-o", filename, image)
+	cmd.Stdout = c.config.Stdout
+	cmd.Stderr = c.config.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("docker save %s: %v", image, err)
+	}
+	skip, size, err := skipLayers(filename, layers)
+	if err == nil && len(skip) > 0 {
+		c.verbose("Skipping %d layers already on the machine.", len(skip))
+		pr, pw := io.Pipe()
+		errc := make(chan error, 1)
+		go func() {
+			err := filterTar(pw, filename, skip)
+			pw.CloseWithError(err)
+			errc <- err
+		}()
+		stderr, err := c.loadStream(pr, image, size, decompress)
+		pr.CloseWithError(errStreamDone)
+		<-errc
+		if err == nil {
+			return nil
+		}
+		c.verbose("Incremental load failed, sending the full image: %s", bytes.TrimSpace(stderr))
+	}
+	f, err = os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	stderr, err := c.loadStream(f, image, fi.Size(), decompress)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	return nil
+}
+
+// loadStream streams r into docker load on the machine and returns
+// the remote stderr output. The remote error takes precedence over
+// any local compression error it causes.
+func (c *client) loadStream(r io.Reader, label string, size int64, decompress string) ([]byte, error) {
+	p := c.newProgress(label, size, 0)
+	pr, wait := compress(p.reader(r), c.flags.deploy.compress)
+	_, stderr, err := c.runWithStdin(pr, "sh", "-c", "set -o pipefail && "+decompress+"docker load")
+	p.Close()
+	pr.CloseWithError(errStreamDone)
+	werr := wait()
+	if err != nil {
+		return stderr, err
+	}
+	return stderr, werr
+}
+
+// remoteLayers returns the layer diff IDs of the image as
+// last deployed on the machine, if any.
+func (c *client) remoteLayers(image string) ([]string, error) {
+	stdout, _, err := c.run("docker", "image", "inspect", "-f", "{{json .RootFS.Layers}}", image)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]string, 0)
+	err = json.Unmarshal(stdout, &layers)
+	if err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// compress returns a reader of r compressed with the named
+// compression and a function that waits for compression to
+// finish and returns its error.
+func compress(r io.Reader, name string) (*io.PipeReader, func() error) {
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		switch name {
+		case "gzip":
+			err = compressGzip(pw, r)
+		case "zstd":
+			err = compressCommand(pw, r, "zstd", "-c", "-q", "-T0")
+		default:
+			_, err = io.Copy(pw, r)
+		}
+		pw.CloseWithError(err)
+		if err == errStreamDone {
+			err = nil
+		}
+		errc <- err
+	}()
+	wait := func() error {
+		return <-errc
+	}
+	return pr, wait
+}
+
+func compressGzip(w io.Writer, r io.Reader) error {
+	gz := gzip.NewWriter(w)
+	_, err := io.Copy(gz, r)
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func compressCommand(w io.Writer, r io.Reader, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// decompressCommand returns the remote shell pipeline prefix
+// that decompresses a stream compressed with the named compression.
+func decompressCommand(name string) (string, error) {
+	switch name {
+	case "", "none":
+		return "", nil
+	case "gzip":
+		return "gzip -d | ", nil
+	case "zstd":
+		return "zstd -d -q | ", nil
+	}
+	return "", fmt.Errorf("Compression must be 'none', 'gzip', or 'zstd'.")
+}
+
+// machineArchs maps uname machine names to Docker image
+// platform architectures, with the variant if any.
+var machineArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+	"i386":    "386",
+	"i686":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// imagePlatformFormat is the docker image inspect format
+// of the image platform, with the variant if any.
+const imagePlatformFormat = "{{.Os}}/{{.Architecture}}{{if .Variant}}/{{.Variant}}{{end}}"
+
+// checkPlatform returns an error if the local image was not built
+// for the operating system and architecture of the machine. The
+// check is skipped with the skip-platform-check flag.
+func (c *client) checkPlatform(image string) error {
+	if c.flags.deploy.skipPlatformCheck {
+		return nil
+	}
+	b, err := exec.Command("docker", "image", "inspect", "-f", imagePlatformFormat, image).Output()
+	if err != nil {
+		return fmt.Errorf("Image '%s' does not exist locally.", image)
+	}
+	stdout, _, err := c.run("uname", "-m")
+	if err != nil {
+		return err
+	}
+	have := strings.TrimSpace(string(b))
+	machine := strings.TrimSpace(string(stdout))
+	return comparePlatform(image, have, machine)
+}
+
+// comparePlatform returns an error if the image platform does not
+// match the platform of the uname machine name.
+func comparePlatform(image, have, machine string) error {
+	arch, ok := machineArchs[machine]
+	if !ok {
+		arch = machine
+	}
+	want := "linux/" + arch
+	if normalizePlatform(have) != normalizePlatform(want) {
+		return fmt.Errorf("Image '%s' is built for '%s' but the machine is '%s'. Rebuild the image with 'docker build --platform %s' or deploy with -skip-platform-check to override.", image, have, want, want)
+	}
+	return nil
+}
+
+// normalizePlatform returns the platform with the default variant
+// of its architecture, if omitted, as Docker does.
+func normalizePlatform(platform string) string {
+	switch platform {
+	case "linux/arm64":
+		return "linux/arm64/v8"
+	case "linux/arm":
+		return "linux/arm/v7"
+	}
+	return platform
+}
+
+// imageSize returns the size in bytes of the local image.
+func imageSize(image string) (int64, error) {
+	b, err := exec.Command("docker", "image", "inspect", "-f", "{{.Size}}", image).Output()
+	if err != nil {
+		return 0, fmt.Errorf("Image '%s' does not exist locally.", image)
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
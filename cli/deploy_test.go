@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"testing"
+)
+
+func TestComparePlatform(t *testing.T) {
+	tests := []struct {
+		have    string
+		machine string
+		ok      bool
+	}{
+		{"linux/amd64", "x86_64", true},
+		{"linux/amd64", "amd64", true},
+		{"linux/arm64", "x86_64", false},
+		{"linux/arm64", "aarch64", true},
+		{"linux/arm64/v8", "aarch64", true},
+		{"linux/amd64", "aarch64", false},
+		{"linux/arm/v7", "armv7l", true},
+		{"linux/arm", "armv7l", true},
+		{"linux/arm/v6", "armv7l", false},
+		{"linux/arm/v6", "armv6l", true},
+		{"linux/arm64", "armv7l", false},
+		{"linux/mips64le", "mips64", false},
+	}
+	for _, tt := range tests {
+		err := comparePlatform("example.com", tt.have, tt.machine)
+		if (err == nil) != tt.ok {
+			t.Errorf("comparePlatform %s on %s\nhave %v\nwant ok %t", tt.have, tt.machine, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/pnelson/cli"
+)
+
+// configVersion is the version of the exported configuration document.
+const configVersion = 1
+
+// scrypt parameters for passphrase encryption of exported secrets.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// configDocument represents an exported machine configuration.
+//
+// The environment holds the secrets of the machine. If encrypted,
+// the environment is omitted in favour of the sealed secrets.
+type configDocument struct {
+	Version    int       `json:"version"`
+	Host       string    `json:"host"`
+	ExportedAt time.Time `json:"exported_at"`
+	manifest
+	Secrets *sealedSecrets `json:"secrets,omitempty"`
+}
+
+// sealedSecrets represents data encrypted with AES-256-GCM
+// using a key derived from a passphrase with scrypt.
+type sealedSecrets struct {
+	KDF    string `json:"kdf"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	Salt   []byte `json:"salt"`
+	Cipher string `json:"cipher"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+func (c *client) configExport(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	m, err := c.machineState()
+	if err != nil {
+		return err
+	}
+	m = portableManifest(m)
+	doc := configDocument{
+		Version:    configVersion,
+		Host:       c.flags.host,
+		ExportedAt: time.Now().UTC(),
+		manifest:   m,
+	}
+	if c.flags.config.encrypt {
+		passphrase, err := c.passphrase(true)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(m.Environment)
+		if err != nil {
+			return err
+		}
+		doc.Secrets, err = seal(b, passphrase)
+		if err != nil {
+			return err
+		}
+		doc.Environment = nil
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = c.config.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+func (c *client) configImport(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var doc configDocument
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return fmt.Errorf("Configuration '%s' is malformed: %v", args[0], err)
+	}
+	if doc.Version < 1 || doc.Version > configVersion {
+		return fmt.Errorf("Configuration '%s' version %d is not supported.", args[0], doc.Version)
+	}
+	if doc.Secrets != nil {
+		passphrase, err := c.passphrase(false)
+		if err != nil {
+			return err
+		}
+		b, err = unseal(doc.Secrets, passphrase)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(b, &doc.Environment)
+		if err != nil {
+			return err
+		}
+	}
+	if !c.flags.config.dryRun {
+		unlock, err := c.lock("config/import")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	have, err := c.machineState()
+	if err != nil {
+		return err
+	}
+	changes := planChanges(doc.manifest, have, false)
+	c.printPlan(changes)
+	if c.flags.config.dryRun {
+		return nil
+	}
+	return c.applyChanges(changes, c.flags.config.force)
+}
+
+// passphrase returns the passphrase for the exported secrets from the
+// environment, otherwise it is read from stdin without echo.
+func (c *client) passphrase(confirm bool) (string, error) {
+	passphrase := os.Getenv("ACROBOX_PASSPHRASE")
+	if passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := c.readSecret("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("Passphrase must not be empty.")
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+	again, err := c.readSecret("Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", errors.New("Passphrases do not match.")
+	}
+	return passphrase, nil
+}
+
+// readSecret prompts on stderr such that stdout can be redirected
+// and reads a line from stdin, without echo if it is a terminal.
+func (c *client) readSecret(prompt string) (string, error) {
+	c.cli.Errorf("%s", prompt)
+	f, ok := c.config.Stdin.(*os.File)
+	if ok && terminal.IsTerminal(int(f.Fd())) {
+		b, err := terminal.ReadPassword(int(f.Fd()))
+		c.cli.Errorf("\n")
+		return string(b), err
+	}
+	return c.readLine()
+}
+
+// seal encrypts data with a key derived from the passphrase.
+func seal(data []byte, passphrase string) (*sealedSecrets, error) {
+	s := &sealedSecrets{
+		KDF:    "scrypt",
+		N:      scryptN,
+		R:      scryptR,
+		P:      scryptP,
+		Salt:   make([]byte, 16),
+		Cipher: "aes-256-gcm",
+	}
+	_, err := rand.Read(s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	s.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(s.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	s.Data = aead.Seal(nil, s.Nonce, data, nil)
+	return s, nil
+}
+
+// unseal decrypts the secrets with a key derived from the passphrase.
+func unseal(s *sealedSecrets, passphrase string) ([]byte, error) {
+	if s.KDF != "scrypt" || s.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("Secrets encrypted with '%s' and '%s' are not supported.", s.KDF, s.Cipher)
+	}
+	// The parameters are untrusted input. Larger values than seal
+	// writes would let a crafted file exhaust memory and time.
+	if s.N > scryptN || s.R > scryptR || s.P > scryptP {
+		return nil, errors.New("Secrets are malformed.")
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, errors.New("Secrets are malformed.")
+	}
+	b, err := aead.Open(nil, s.Nonce, s.Data, nil)
+	if err != nil {
+		return nil, errors.New("Unable to decrypt secrets. Check the passphrase.")
+	}
+	return b, nil
+}
+
+func (s *sealedSecrets) aead(passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), s.Salt, s.N, s.R, s.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pnelson/cli"
+)
+
+func TestSeal(t *testing.T) {
+	data := []byte(`{"example.com":{"TOKEN":"hunter2"}}`)
+	s, err := seal(data, "correct horse")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded sealedSecrets
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := unseal(&decoded, "correct horse")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, data) {
+		t.Errorf("unseal\nhave %s\nwant %s", have, data)
+	}
+	_, err = unseal(&decoded, "wrong horse")
+	if err == nil {
+		t.Errorf("unseal with the wrong passphrase should fail")
+	}
+	decoded.Data[0] ^= 1
+	_, err = unseal(&decoded, "correct horse")
+	if err == nil {
+		t.Errorf("unseal of tampered data should fail")
+	}
+	decoded.Data[0] ^= 1
+	for _, tamper := range []func(*sealedSecrets){
+		func(s *sealedSecrets) { s.N = scryptN << 10 },
+		func(s *sealedSecrets) { s.R = scryptR << 10 },
+		func(s *sealedSecrets) { s.P = scryptP << 10 },
+	} {
+		params := decoded
+		tamper(&params)
+		_, err = unseal(&params, "correct horse")
+		if err == nil {
+			t.Errorf("unseal with parameters %d, %d, %d should fail", params.N, params.R, params.P)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	c := &client{config: &Config{Stdin: strings.NewReader("pw\npw\n")}}
+	c.cli = cli.New(AppName, nil, nil, cli.Stderr(io.Discard))
+	have, err := c.passphrase(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have != "pw" {
+		t.Errorf("passphrase\nhave '%s'\nwant '%s'", have, "pw")
+	}
+	c = &client{config: &Config{Stdin: strings.NewReader("pw\nwp\n")}}
+	c.cli = cli.New(AppName, nil, nil, cli.Stderr(io.Discard))
+	_, err = c.passphrase(true)
+	if err == nil {
+		t.Errorf("passphrase should be confirmed")
+	}
+	c = &client{config: &Config{Stdin: strings.NewReader("pw\nacrobox\n")}}
+	c.cli = cli.New(AppName, nil, nil, cli.Stdout(io.Discard), cli.Stderr(io.Discard))
+	c.flags.host = "acrobox"
+	_, err = c.passphrase(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.promptToAgree()
+	if err != nil {
+		t.Errorf("prompt should read the line after the passphrase: %v", err)
+	}
+	prev, ok := os.LookupEnv("ACROBOX_PASSPHRASE")
+	os.Setenv("ACROBOX_PASSPHRASE", "env")
+	defer func() {
+		if ok {
+			os.Setenv("ACROBOX_PASSPHRASE", prev)
+		} else {
+			os.Unsetenv("ACROBOX_PASSPHRASE")
+		}
+	}()
+	have, err = c.passphrase(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have != "env" {
+		t.Errorf("passphrase\nhave '%s'\nwant '%s'", have, "env")
+	}
+}
+
+func TestConfigDocument(t *testing.T) {
+	doc := configDocument{
+		Version: configVersion,
+		Host:    "acrobox",
+		manifest: manifest{
+			Containers: map[string]manifestContainer{"web": {Image: "example.com", Site: "example.com"}},
+			Databases:  []string{"example"},
+		},
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var have configDocument
+	err = json.Unmarshal(b, &have)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, doc) {
+		t.Errorf("document\nhave %+v\nwant %+v", have, doc)
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"version", "containers", "databases"} {
+		_, ok := fields[key]
+		if !ok {
+			t.Errorf("document should have top level key '%s'", key)
+		}
+	}
+}
@@ -1,21 +1,39 @@
 package cli
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pnelson/cli"
+)
 
 // flags represents the command flag parameters.
 type flags struct {
-	addr    string // acrobox.io service addr
-	auth    string // acrobox.io api token
-	host    string // machine hostname
-	port    string // machine ssh port without the colon
-	verbose bool
-	init    flagsInit
-	cancel  flagsCancel
-	renew   flagsRenew
-	destroy flagsDestroy
-	status  flagsStatus
-	metrics flagsMetrics
-	restore flagsRestore
+	addr     string // acrobox.io service addr
+	auth     string // acrobox.io api token
+	host     string // machine hostname
+	port     string // machine ssh port without the colon
+	verbose  bool
+	init     flagsInit
+	cancel   flagsCancel
+	renew    flagsRenew
+	resize   flagsResize
+	volume   flagsVolumeResize
+	catalog  flagsCatalog
+	destroy  flagsDestroy
+	status   flagsStatus
+	metrics  flagsMetrics
+	restore  flagsRestore
+	agent    flagsAgent
+	deploy   flagsDeploy
+	build    flagsBuild
+	deploys  flagsDeploys
+	lock     flagsLock
+	promote  flagsPromote
+	manifest flagsManifest
+	config   flagsConfig
+	migrate  flagsMigrate
 }
 
 // flagsInit represents the flags for initializing a new machine.
@@ -28,6 +46,12 @@ type flagsInit struct {
 	PublicKey   string `json:"public_key"`   // ssh authorized key
 	AccessToken string `json:"access_token"` // $DIGITALOCEAN_ACCESS_TOKEN
 	force       bool
+	resume      bool
+	rollback    bool
+	// Deadlines of the waits for the machine to come up.
+	provisionTimeout time.Duration
+	sshTimeout       time.Duration
+	setupTimeout     time.Duration
 }
 
 // flagsCancel represents the flags for cancelling service.
@@ -40,6 +64,28 @@ type flagsRenew struct {
 	force bool
 }
 
+// flagsResize represents the flags for resizing a machine.
+type flagsResize struct {
+	Size        string `json:"size"`         // droplet size slug
+	AccessToken string `json:"access_token"` // $DIGITALOCEAN_ACCESS_TOKEN
+	force       bool
+	timeout     time.Duration
+}
+
+// flagsVolumeResize represents the flags for resizing the data volume.
+type flagsVolumeResize struct {
+	DataSize    int    `json:"data_size"`    // in GB, can only grow
+	AccessToken string `json:"access_token"` // $DIGITALOCEAN_ACCESS_TOKEN
+	force       bool
+	timeout     time.Duration
+}
+
+// flagsCatalog represents the flags for the region and size catalog.
+type flagsCatalog struct {
+	region string
+	format string
+}
+
 // flagsDestroy represents the flags for destroying a machine.
 type flagsDestroy struct {
 	AccessToken string `json:"access_token"` // $DIGITALOCEAN_ACCESS_TOKEN
@@ -61,6 +107,74 @@ type flagsRestore struct {
 	force bool
 }
 
+// flagsAgent represents the flags for the connection agent.
+type flagsAgent struct {
+	timeout time.Duration
+	detach  bool
+}
+
+// flagsDeploy represents the flags for deploying an image.
+type flagsDeploy struct {
+	compress string
+	registry string
+	keep     int // revisions to keep on the machine
+	release  string
+
+	skipPlatformCheck bool
+
+	healthPath     string
+	healthTimeout  time.Duration
+	healthRollback bool
+}
+
+// flagsMigrate represents the flags for migrating a machine.
+type flagsMigrate struct {
+	from  string
+	to    string
+	force bool
+}
+
+// flagsConfig represents the flags for exporting and importing
+// the machine configuration.
+type flagsConfig struct {
+	encrypt bool
+	dryRun  bool
+	force   bool
+}
+
+// flagsManifest represents the flags for planning and applying
+// the manifest.
+type flagsManifest struct {
+	file  string
+	prune bool
+	force bool
+}
+
+// flagsPromote represents the flags for promoting an image.
+type flagsPromote struct {
+	from   string
+	to     string
+	digest string
+}
+
+// flagsLock represents the flags for the deploy lock.
+type flagsLock struct {
+	wait  bool
+	force bool
+}
+
+// flagsDeploys represents the flags for the deploy history.
+type flagsDeploys struct {
+	format string
+}
+
+// flagsBuild represents the flags for building an image.
+type flagsBuild struct {
+	tag    string
+	file   string
+	deploy bool
+}
+
 // flagInt represents an integer flag.
 type flagInt struct{}
 
@@ -76,3 +190,72 @@ func (f flagInt) Parse(value string) interface{} {
 func (f flagInt) HasArg() bool {
 	return true
 }
+
+// flagDuration represents a positive time.Duration flag.
+//
+// The FlagKind interface cannot fail, so values that cannot
+// be parsed or are not positive are appended to errs to be
+// reported as a usage error before the command runs. Invalid
+// values are ignored if errs is nil.
+type flagDuration struct {
+	errs *[]error
+}
+
+// Parse returns value as a time.Duration.
+//
+// Parse implements the FlagKind interface.
+func (f flagDuration) Parse(value string) interface{} {
+	d, err := time.ParseDuration(value)
+	if f.errs != nil && (err != nil || d <= 0) {
+		*f.errs = append(*f.errs, fmt.Errorf("Duration '%s' must be positive and have a unit such as '60s' or '10m'.", value))
+	}
+	return d
+}
+
+// HasArg implements the FlagKind interface.
+func (f flagDuration) HasArg() bool {
+	return true
+}
+
+// parseProxyFlags parses the given flags out of the args of a proxied
+// command and returns the remaining args in their original order.
+//
+// Proxied commands are not parsed by the cli package such that the
+// remote command receives its flags untouched. This allows abx to
+// handle a few flags of its own before proxying the rest.
+func parseProxyFlags(args []string, flags []*cli.Flag) ([]string, error) {
+	_, err := cli.Parse(nil, flags)
+	if err != nil {
+		return nil, err
+	}
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			rest = append(rest, arg)
+			continue
+		}
+		_, err = cli.Parse([]string{arg}, flags)
+		switch err.(type) {
+		case nil:
+			continue
+		case cli.ErrUndefinedFlag, cli.ErrFlagSyntax:
+			rest = append(rest, arg)
+			continue
+		case cli.ErrRequiresArg:
+			if i+1 < len(args) {
+				_, err = cli.Parse(args[i:i+2], flags)
+				if err == nil {
+					i++
+					continue
+				}
+			}
+		}
+		return nil, err
+	}
+	return rest, nil
+}
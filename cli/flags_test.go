@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pnelson/cli"
+)
+
+func TestParseProxyFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     []string
+		compress string
+		force    bool
+	}{
+		{[]string{"image"}, []string{"image"}, "none", false},
+		{[]string{"-t", "10", "image"}, []string{"-t", "10", "image"}, "none", false},
+		{[]string{"-z", "gzip", "-t", "10", "image"}, []string{"-t", "10", "image"}, "gzip", false},
+		{[]string{"-t", "10", "-compress=zstd", "image"}, []string{"-t", "10", "image"}, "zstd", false},
+		{[]string{"-x", "image", "-force"}, []string{"-x", "image"}, "none", true},
+		{[]string{"image", "--", "-force"}, []string{"image", "--", "-force"}, "none", false},
+	}
+	for _, tt := range tests {
+		var compress string
+		var force bool
+		have, err := parseProxyFlags(tt.args, []*cli.Flag{
+			cli.NewFlag("compress", &compress, cli.DefaultValue("none"), cli.ShortFlag("z")),
+			cli.NewFlag("force", &force, cli.Bool()),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("parseProxyFlags %v\nhave %v\nwant %v", tt.args, have, tt.want)
+		}
+		if compress != tt.compress || force != tt.force {
+			t.Errorf("parseProxyFlags %v\nhave %s %t\nwant %s %t", tt.args, compress, force, tt.compress, tt.force)
+		}
+	}
+	var compress string
+	_, err := parseProxyFlags([]string{"image", "-compress"}, []*cli.Flag{
+		cli.NewFlag("compress", &compress),
+	})
+	if err == nil {
+		t.Fatalf("parseProxyFlags with missing argument should fail")
+	}
+}
+
+func TestFlagDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"60s", true},
+		{"10m", true},
+		{"60", false},
+		{"0s", false},
+		{"-1m", false},
+		{"soon", false},
+	}
+	for _, tt := range tests {
+		var errs []error
+		var d time.Duration
+		_, err := cli.Parse([]string{"-timeout=" + tt.value}, []*cli.Flag{
+			cli.NewFlag("timeout", &d, cli.Kind(flagDuration{&errs})),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (len(errs) == 0) != tt.ok {
+			t.Errorf("flagDuration %q\nhave %v\nwant valid %t", tt.value, errs, tt.ok)
+		}
+	}
+	commands := [][]string{
+		{"agent", "-timeout", "10"},
+		{"deploy", "-health-timeout", "10", "example.com"},
+		{"init", "-ssh-timeout", "0s"},
+		{"resize", "-timeout", "10"},
+		{"volume/resize", "-timeout=-1m"},
+	}
+	for _, args := range commands {
+		config := &Config{
+			Args:   append([]string{"abx"}, args...),
+			Home:   t.TempDir(),
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+		}
+		err := Run(config)
+		if err != cli.ErrExitFailure {
+			t.Errorf("invalid duration should be a usage error\nhave %v for %q", err, args)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// healthProbeTimeout is the timeout of a single site probe.
+	healthProbeTimeout = 5 * time.Second
+
+	// healthLogLines is the number of log lines shown
+	// for each container of an unhealthy deploy.
+	healthLogLines = "50"
+)
+
+// healthUnknownError represents an error that prevents checking the
+// health of a deploy at all. Such a deploy is not rolled back.
+type healthUnknownError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e healthUnknownError) Error() string {
+	return e.err.Error()
+}
+
+// healthGate waits for the configured containers of the deployed image
+// to become healthy. The containers are considered healthy once they
+// are running without restarts and sites respond to the health path.
+//
+// The logs of each container are shown if the deploy is unhealthy
+// and the previous revision is restored if rollback is enabled.
+func (c *client) healthGate(image string) error {
+	if c.flags.deploy.healthPath == "" {
+		return nil
+	}
+	containers, err := c.imageContainers(image)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		c.verbose("No containers are configured for '%s'.", image)
+		return nil
+	}
+	c.step(colorINF, "Waiting for '%s' to become healthy.", image)
+	ctx, stop := interruptContext()
+	defer stop()
+	err = c.waitForHealthy(ctx, containers, c.flags.deploy.healthPath, c.flags.deploy.healthTimeout)
+	if err == nil {
+		c.step(colorINF, "Deploy of '%s' is healthy.", image)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	if errors.As(err, &healthUnknownError{}) {
+		return fmt.Errorf("Unable to check the health of '%s': %v", image, err)
+	}
+	for _, ct := range containers {
+		c.containerLogs(ct.Name)
+	}
+	if c.flags.deploy.healthRollback {
+		c.step(colorWRN, "Deploy of '%s' is unhealthy. Rolling back.", image)
+		rerr := c.rollback([]string{image})
+		if rerr != nil {
+			c.step(colorERR, "Unable to roll back '%s': %v", image, rerr)
+		}
+	}
+	return fmt.Errorf("Deploy of '%s' is unhealthy: %v", image, err)
+}
+
+// imageContainers returns the service and site containers
+// configured with abx add for the image.
+func (c *client) imageContainers(image string) ([]acroboxdContainer, error) {
+	stdout, stderr, err := c.run("docker", "exec", "acroboxd", "acroboxd", "list", "-format", "json")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return nil, err
+	}
+	containers := make([]acroboxdContainer, 0)
+	err = json.Unmarshal(stdout, &containers)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]acroboxdContainer, 0, len(containers))
+	for _, ct := range containers {
+		if imageName(ct.Image) == image && ct.Task == "" {
+			rv = append(rv, ct)
+		}
+	}
+	return rv, nil
+}
+
+// waitForHealthy polls the containers until they are healthy, one
+// of them fails, the timeout is exceeded, or ctx is done. Restarts
+// are counted from the first check.
+func (c *client) waitForHealthy(ctx context.Context, containers []acroboxdContainer, path string, timeout time.Duration) error {
+	restarts := make(map[string]int)
+	waiting := ""
+	err := c.poll(ctx, "waiting for the deploy to become healthy", timeout, func(ctx context.Context) error {
+		var err error
+		waiting, err = c.checkHealth(containers, path, restarts)
+		if err != nil {
+			return permanent(err)
+		}
+		if waiting != "" {
+			return errors.New(waiting)
+		}
+		return nil
+	})
+	if err != nil && waiting != "" && ctx.Err() == nil {
+		return fmt.Errorf("timeout exceeded after %s: %s", timeout, waiting)
+	}
+	return err
+}
+
+// checkHealth returns the reason any of the containers are not yet
+// healthy, if any, or an error if any of them have failed.
+func (c *client) checkHealth(containers []acroboxdContainer, path string, restarts map[string]int) (string, error) {
+	names := make([]string, len(containers))
+	for i, ct := range containers {
+		names[i] = ct.Name
+	}
+	args := append([]string{"container", "inspect"}, names...)
+	stdout, stderr, err := c.run("docker", args...)
+	if err != nil {
+		return strings.TrimSpace(string(stderr)), nil
+	}
+	states := make([]dockerContainer, 0)
+	err = json.Unmarshal(stdout, &states)
+	if err != nil {
+		return "", err
+	}
+	if len(states) != len(containers) {
+		return "containers are not yet created", nil
+	}
+	for i, ct := range containers {
+		state := states[i]
+		baseline, ok := restarts[ct.Name]
+		if !ok {
+			baseline = state.RestartCount
+			restarts[ct.Name] = baseline
+		}
+		waiting, err := containerHealth(ct.Name, state, baseline)
+		if err != nil || waiting != "" {
+			return waiting, err
+		}
+		if ct.Site == "" {
+			continue
+		}
+		err = c.probeSite(ct, state, path)
+		if errors.As(err, &healthUnknownError{}) {
+			return "", err
+		}
+		if err != nil {
+			return err.Error(), nil
+		}
+	}
+	return "", nil
+}
+
+// containerHealth returns the reason the container is not yet healthy,
+// if any, or an error if it has stopped, restarted since the baseline
+// restart count, or been reported unhealthy by docker.
+func containerHealth(name string, state dockerContainer, baseline int) (string, error) {
+	switch state.State.Status {
+	case "exited", "dead":
+		return "", fmt.Errorf("container '%s' exited with code %d", name, state.State.ExitCode)
+	}
+	if state.RestartCount > baseline {
+		return "", fmt.Errorf("container '%s' restarted %d times", name, state.RestartCount-baseline)
+	}
+	if !state.State.Running {
+		return fmt.Sprintf("container '%s' is %s", name, state.State.Status), nil
+	}
+	if state.State.Health != nil {
+		switch state.State.Health.Status {
+		case "unhealthy":
+			return "", fmt.Errorf("container '%s' is unhealthy", name)
+		case "healthy":
+		default:
+			return fmt.Sprintf("container '%s' health is %s", name, state.State.Health.Status), nil
+		}
+	}
+	return "", nil
+}
+
+// probeSite returns an error if the site container does not
+// successfully respond to a request for path. The request is made
+// from the machine by way of the SSH connection.
+func (c *client) probeSite(ct acroboxdContainer, state dockerContainer, path string) error {
+	ip := ""
+	for _, network := range state.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			ip = network.IPAddress
+			break
+		}
+	}
+	if ip == "" {
+		return fmt.Errorf("container '%s' has no address", ct.Name)
+	}
+	port := ct.Port
+	if port == "" {
+		port = "8080"
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return c.dialMachine(network, addr)
+	}
+	hc := &http.Client{
+		Timeout:   healthProbeTimeout,
+		Transport: &http.Transport{DialContext: dial, DisableKeepAlives: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := "http://" + net.JoinHostPort(ip, port) + path
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	host := ct.Site
+	i := strings.Index(host, "/")
+	if i != -1 {
+		host = host[:i]
+	}
+	req.Host = host
+	resp, err := hc.Do(req)
+	if err != nil {
+		var oerr *ssh.OpenChannelError
+		if errors.As(err, &oerr) && oerr.Reason == ssh.Prohibited {
+			return c.probeSiteFromMachine(ct.Name, host, url)
+		}
+		return fmt.Errorf("site '%s' is unreachable: %v", ct.Name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("site '%s' responded with %s", ct.Name, resp.Status)
+	}
+	return nil
+}
+
+// probeSiteFromMachine requests the url with wget from within the
+// acroboxd container for machines that prohibit TCP forwarding.
+func (c *client) probeSiteFromMachine(name, host, url string) error {
+	timeout := strconv.Itoa(int(healthProbeTimeout / time.Second))
+	_, stderr, err := c.run("docker", "exec", "acroboxd", "wget", "-q", "-T", timeout, "-O", "/dev/null", "--header", "Host: "+host, url)
+	if err == nil {
+		return nil
+	}
+	var eerr *ssh.ExitError
+	if errors.As(err, &eerr) && (eerr.ExitStatus() == 126 || eerr.ExitStatus() == 127) {
+		return healthUnknownError{fmt.Errorf("machine prohibits TCP forwarding and wget is unavailable: %s", strings.TrimSpace(string(stderr)))}
+	}
+	return fmt.Errorf("site '%s' is unreachable: %s", name, strings.TrimSpace(string(stderr)))
+}
+
+// containerLogs writes the most recent logs of the container to stderr.
+func (c *client) containerLogs(name string) {
+	stdout, stderr, err := c.run("docker", "logs", "--tail", healthLogLines, name)
+	if err != nil {
+		return
+	}
+	c.cli.Errorf("\nLogs of '%s':\n%s%s\n", name, stdout, stderr)
+}
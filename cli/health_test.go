@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerHealth(t *testing.T) {
+	tests := []struct {
+		state    string
+		baseline int
+		waiting  bool
+		err      bool
+	}{
+		{`{"State":{"Status":"running","Running":true}}`, 0, false, false},
+		{`{"State":{"Status":"created"}}`, 0, true, false},
+		{`{"State":{"Status":"exited","ExitCode":1}}`, 0, false, true},
+		{`{"RestartCount":2,"State":{"Status":"running","Running":true}}`, 2, false, false},
+		{`{"RestartCount":3,"State":{"Status":"restarting"}}`, 2, false, true},
+		{`{"State":{"Status":"running","Running":true,"Health":{"Status":"starting"}}}`, 0, true, false},
+		{`{"State":{"Status":"running","Running":true,"Health":{"Status":"healthy"}}}`, 0, false, false},
+		{`{"State":{"Status":"running","Running":true,"Health":{"Status":"unhealthy"}}}`, 0, false, true},
+	}
+	for _, tt := range tests {
+		var state dockerContainer
+		err := json.Unmarshal([]byte(tt.state), &state)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		waiting, err := containerHealth("web", state, tt.baseline)
+		if (waiting != "") != tt.waiting {
+			t.Errorf("waiting %s\nhave '%s'\nwant %t", tt.state, waiting, tt.waiting)
+		}
+		if (err != nil) != tt.err {
+			t.Errorf("error %s\nhave %v\nwant %t", tt.state, err, tt.err)
+		}
+	}
+}
+
+func TestWaitForHealthy(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	clock := &testClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	c.clock = clock
+	state := filepath.Join(home, "state.json")
+	ss.fake(t, "docker", "cat '"+state+"'")
+	containers := []acroboxdContainer{{Name: "web"}}
+	tests := []struct {
+		state string
+		err   string
+		waits bool
+	}{
+		{`[{"State":{"Status":"running","Running":true}}]`, "", false},
+		{`[{"State":{"Status":"exited","ExitCode":1}}]`, "exited with code 1", false},
+		{`[{"State":{"Status":"created"}}]`, "timeout exceeded after 1m0s: container 'web' is created", true},
+	}
+	for _, tt := range tests {
+		err := os.WriteFile(state, []byte(tt.state), 0640)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clock.waits = nil
+		err = c.waitForHealthy(context.Background(), containers, "/", time.Minute)
+		if tt.err == "" && err != nil {
+			t.Errorf("waitForHealthy %s\nunexpected error: %v", tt.state, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("waitForHealthy %s\nhave %v\nwant %s", tt.state, err, tt.err)
+		}
+		if (len(clock.waits) > 0) != tt.waits {
+			t.Errorf("waitForHealthy %s\nhave waits %v\nwant waits %t", tt.state, clock.waits, tt.waits)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.waitForHealthy(ctx, containers, "/", time.Minute)
+	if err == nil || !strings.HasPrefix(err.Error(), "Interrupted") {
+		t.Errorf("waitForHealthy should be interrupted\nhave %v", err)
+	}
+}
+
+func TestProbeSiteProhibited(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	ss.fake(t, "docker", `for url; do :; done
+case "$url" in
+*/healthz) exit 0 ;;
+*/missing) echo "wget: not found" >&2; exit 127 ;;
+*) echo "wget: server returned error: HTTP/1.1 502 Bad Gateway" >&2; exit 1 ;;
+esac`)
+	var state dockerContainer
+	err := json.Unmarshal([]byte(`{"NetworkSettings":{"Networks":{"acrobox":{"IPAddress":"172.18.0.2"}}}}`), &state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ct := acroboxdContainer{Name: "web", Site: "example.com"}
+	err = c.probeSite(ct, state, "/healthz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.probeSite(ct, state, "/down")
+	if err == nil || !strings.Contains(err.Error(), "502 Bad Gateway") {
+		t.Fatalf("unreachable site\nhave %v\nwant error containing '502 Bad Gateway'", err)
+	}
+	if errors.As(err, &healthUnknownError{}) {
+		t.Fatalf("unreachable site should not be unknown health: %v", err)
+	}
+	err = c.probeSite(ct, state, "/missing")
+	if !errors.As(err, &healthUnknownError{}) {
+		t.Fatalf("probe without wget should be unknown health\nhave %v", err)
+	}
+}
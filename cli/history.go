@@ -0,0 +1,344 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pnelson/cli"
+)
+
+const (
+	// historyFile is the deploy history on the machine
+	// with one JSON encoded deployRecord per line.
+	historyFile = "/acrobox/deploys.jsonl"
+
+	// revisionTimeFormat is the time layout of revision tags.
+	revisionTimeFormat = "20060102T150405Z"
+)
+
+// deployRecord represents an entry of the deploy history.
+//
+// Each deploy tags the image on the machine with the revision
+// such that it can be restored as the latest image later on.
+type deployRecord struct {
+	Time     time.Time `json:"time"`
+	Image    string    `json:"image"`
+	Revision string    `json:"revision"`
+	Digest   string    `json:"digest"`
+	Commit   string    `json:"commit,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Rollback bool      `json:"rollback,omitempty"`
+}
+
+// release runs the release task of the image, restarts its
+// containers through acroboxd, and records the deploy in the history.
+func (c *client) release(image string, args []string) error {
+	err := c.runReleaseTask(image)
+	if err != nil {
+		return err
+	}
+	err = c.acroboxd("deploy", args)
+	if err != nil {
+		return err
+	}
+	return c.recordDeploy(image)
+}
+
+// recordDeploy tags the latest image with a new revision, appends
+// it to the deploy history, and prunes the oldest revisions.
+func (c *client) recordDeploy(image string) error {
+	digest, err := c.imageDigest(image)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	r := deployRecord{
+		Time:     now,
+		Image:    image,
+		Revision: revisionTag(now, digest),
+		Digest:   digest,
+		Commit:   gitCommit(),
+		User:     localUser(),
+	}
+	_, stderr, err := c.run("docker", "tag", image+":latest", image+":"+r.Revision)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	err = c.appendHistory(r)
+	if err != nil {
+		return err
+	}
+	c.verbose("Tagged revision '%s'.", r.Revision)
+	return c.pruneRevisions(image, c.flags.deploy.keep)
+}
+
+func (c *client) deploys(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	image := imageName(args[0])
+	records, err := c.readHistory(image)
+	if err != nil {
+		return err
+	}
+	switch c.flags.deploys.format {
+	case "term":
+	case "json":
+		return json.NewEncoder(c.config.Stdout).Encode(records)
+	default:
+		return fmt.Errorf("Format must be 'term' or 'json'.")
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("Image '%s' has no deploy history.", image)
+	}
+	current, _ := c.imageDigest(image)
+	now := time.Now()
+	w := tabwriter.NewWriter(c.config.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "REVISION\tDEPLOYED AT\tCOMMIT\tUSER\t\n")
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		note := ""
+		if r.Rollback {
+			note = "rollback"
+		}
+		if r.Digest == current {
+			note = strings.TrimSpace("current " + note)
+			current = ""
+		}
+		commit := r.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s%s\t%s\t%s\t%s\n", r.Revision, formatTime(r.Time.Local()), formatDuration(r.Time, now), commit, r.User, note)
+	}
+	return w.Flush()
+}
+
+func (c *client) rollback(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return cli.ErrUsage
+	}
+	image := imageName(args[0])
+	unlock, err := c.lock("rollback " + image)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	revision := ""
+	if len(args) == 2 {
+		revision = args[1]
+	}
+	records, err := c.readHistory(image)
+	if err != nil {
+		return err
+	}
+	current, err := c.imageDigest(image)
+	if err != nil {
+		return err
+	}
+	r, err := rollbackTarget(records, image, current, revision)
+	if err != nil {
+		return err
+	}
+	_, stderr, err := c.run("docker", "tag", image+":"+r.Revision, image+":latest")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	c.step(colorINF, "Rolling back '%s' to revision '%s'.", image, r.Revision)
+	err = c.acroboxd("deploy", []string{image})
+	if err != nil {
+		return err
+	}
+	r.Time = time.Now().UTC()
+	r.User = localUser()
+	r.Rollback = true
+	return c.appendHistory(r)
+}
+
+// rollbackTarget returns the record of the revision of the image to
+// roll back to. If revision is empty then the most recent revision
+// that is not the current digest is returned.
+func rollbackTarget(records []deployRecord, image, current, revision string) (deployRecord, error) {
+	if len(records) == 0 {
+		return deployRecord{}, fmt.Errorf("Image '%s' has no deploy history.", image)
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if revision == "" && r.Digest != current {
+			return r, nil
+		}
+		if revision != "" && r.Revision == revision {
+			return r, nil
+		}
+	}
+	if revision != "" {
+		return deployRecord{}, fmt.Errorf("Revision '%s' of image '%s' does not exist.", revision, image)
+	}
+	return deployRecord{}, fmt.Errorf("Image '%s' has no previous revision.", image)
+}
+
+// pruneRevisions removes the tags and history of the revisions of the
+// image beyond the most recent keep revisions. Nothing is pruned if
+// keep is not positive.
+func (c *client) pruneRevisions(image string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	records, err := c.readHistory("")
+	if err != nil {
+		return err
+	}
+	records, drop := pruneHistory(records, image, keep)
+	if len(drop) == 0 {
+		return nil
+	}
+	for _, revision := range drop {
+		_, stderr, err := c.run("docker", "image", "rm", image+":"+revision)
+		if err != nil {
+			c.verbose("Unable to remove revision '%s': %s", revision, bytes.TrimSpace(stderr))
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		err = enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	_, stderr, err := c.runWithStdin(&buf, "sh", "-c", `cat > "$1.tmp" && mv "$1.tmp" "$1"`, "sh", historyFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	c.verbose("Pruned %d revisions of '%s'.", len(drop), image)
+	return nil
+}
+
+// pruneHistory returns the records without the revisions of the image
+// beyond the most recent keep revisions, and the revisions dropped.
+func pruneHistory(records []deployRecord, image string, keep int) ([]deployRecord, []string) {
+	seen := make(map[string]bool)
+	drop := make([]string, 0)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Image != image || seen[r.Revision] {
+			continue
+		}
+		seen[r.Revision] = true
+		if len(seen) > keep {
+			drop = append(drop, r.Revision)
+		}
+	}
+	if len(drop) == 0 {
+		return records, drop
+	}
+	dropped := make(map[string]bool)
+	for _, revision := range drop {
+		dropped[revision] = true
+	}
+	rv := make([]deployRecord, 0, len(records))
+	for _, r := range records {
+		if r.Image == image && dropped[r.Revision] {
+			continue
+		}
+		rv = append(rv, r)
+	}
+	return rv, drop
+}
+
+// readHistory returns the deploy history of the image on the
+// machine, oldest first. All images are returned if image is empty.
+func (c *client) readHistory(image string) ([]deployRecord, error) {
+	stdout, stderr, err := c.run("sh", "-c", `if [ -f "$1" ]; then cat "$1"; fi`, "sh", historyFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return nil, err
+	}
+	return parseHistory(stdout, image)
+}
+
+// parseHistory returns the records of the image, or of all
+// images if image is empty, from the history file contents.
+func parseHistory(b []byte, image string) ([]deployRecord, error) {
+	records := make([]deployRecord, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var r deployRecord
+		err := json.Unmarshal(line, &r)
+		if err != nil {
+			return nil, fmt.Errorf("Deploy history '%s' is malformed: %v", historyFile, err)
+		}
+		if image == "" || r.Image == image {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// appendHistory appends the record to the deploy history.
+func (c *client) appendHistory(r deployRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, stderr, err := c.runWithStdin(bytes.NewReader(b), "sh", "-c", `cat >> "$1"`, "sh", historyFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	return nil
+}
+
+// imageDigest returns the image ID of the latest image on the machine.
+func (c *client) imageDigest(image string) (string, error) {
+	stdout, _, err := c.run("docker", "image", "inspect", "-f", "{{.Id}}", image+":latest")
+	if err != nil {
+		return "", fmt.Errorf("Image '%s' does not exist on the machine.", image)
+	}
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// revisionTag returns the revision tag for an image deployed
+// at t with the digest in the form 20060102T150405Z-0123456789ab.
+func revisionTag(t time.Time, digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return t.UTC().Format(revisionTimeFormat) + "-" + digest
+}
+
+// gitCommit returns the commit of the local git working
+// directory, if any.
+func gitCommit() string {
+	b, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// localUser returns the name of the local user.
+func localUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+	return u.Username
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRevisionTag(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+	have := revisionTag(now, "sha256:0123456789abcdef0123")
+	want := "20260102T080405Z-0123456789ab"
+	if have != want {
+		t.Errorf("revisionTag\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestParseHistory(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	records := []deployRecord{
+		{Image: "web", Revision: "1", Digest: "a"},
+		{Image: "api", Revision: "2", Digest: "b"},
+		{Image: "web", Revision: "3", Digest: "c", Rollback: true},
+	}
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	buf.WriteString("\n")
+	have, err := parseHistory(buf.Bytes(), "web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []deployRecord{records[0], records[2]}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("parseHistory\nhave %v\nwant %v", have, want)
+	}
+	_, err = parseHistory([]byte("{\n"), "")
+	if err == nil {
+		t.Errorf("malformed history should fail")
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	records := []deployRecord{
+		{Image: "web", Revision: "1"},
+		{Image: "api", Revision: "1"},
+		{Image: "web", Revision: "2"},
+		{Image: "web", Revision: "3"},
+		{Image: "web", Revision: "1", Rollback: true},
+		{Image: "web", Revision: "4"},
+	}
+	have, drop := pruneHistory(records, "web", 2)
+	want := []deployRecord{records[0], records[1], records[4], records[5]}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("records\nhave %v\nwant %v", have, want)
+	}
+	if !reflect.DeepEqual(drop, []string{"3", "2"}) {
+		t.Errorf("drop\nhave %v\nwant %v", drop, []string{"3", "2"})
+	}
+	have, drop = pruneHistory(records, "web", 4)
+	if len(drop) != 0 || len(have) != len(records) {
+		t.Errorf("nothing should be pruned\nhave %v %v", have, drop)
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	records := []deployRecord{
+		{Revision: "1", Digest: "a"},
+		{Revision: "2", Digest: "b"},
+		{Revision: "3", Digest: "c"},
+	}
+	tests := []struct {
+		current  string
+		revision string
+		want     string
+	}{
+		{"c", "", "2"},
+		{"b", "", "3"},
+		{"c", "1", "1"},
+	}
+	for _, tt := range tests {
+		r, err := rollbackTarget(records, "web", tt.current, tt.revision)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if r.Revision != tt.want {
+			t.Errorf("rollbackTarget(%q, %q)\nhave %s\nwant %s", tt.current, tt.revision, r.Revision, tt.want)
+		}
+	}
+	_, err := rollbackTarget(records, "web", "c", "9")
+	if err == nil {
+		t.Errorf("unknown revision should fail")
+	}
+	_, err = rollbackTarget(records[:1], "web", "a", "")
+	if err == nil {
+		t.Errorf("no previous revision should fail")
+	}
+	_, err = rollbackTarget(nil, "web", "", "")
+	if err == nil {
+		t.Errorf("no history should fail")
+	}
+}
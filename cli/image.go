@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// imageManifest represents an entry of the manifest.json file
+// within an image archive written by docker save.
+type imageManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// imageConfig represents the subset of the image configuration
+// within an image archive that is of interest to abx.
+type imageConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// readImageArchive returns the manifest entries and their image
+// configurations, in order, of the image archive filename.
+func readImageArchive(filename string) ([]imageManifest, []imageConfig, error) {
+	b, err := readTarEntry(filename, "manifest.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	manifests := make([]imageManifest, 0)
+	err = json.Unmarshal(b, &manifests)
+	if err != nil {
+		return nil, nil, err
+	}
+	configs := make([]imageConfig, len(manifests))
+	for i, m := range manifests {
+		b, err = readTarEntry(filename, m.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = json.Unmarshal(b, &configs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(configs[i].RootFS.DiffIDs) != len(m.Layers) {
+			return nil, nil, fmt.Errorf("Image archive layers do not match the configuration of '%s'.", m.Config)
+		}
+	}
+	return manifests, configs, nil
+}
+
+// skipLayers returns the set of layer files within the image
+// archive filename that may be omitted because the machine
+// already has the layers, given the diff IDs of the layers of
+// the previously deployed image, and the total size of the
+// remaining entries.
+//
+// Layers are identified by their chain rather than their diff
+// ID alone so only the common prefix of layers is skipped.
+// The docker load command only reads a layer file if the
+// layer chain does not already exist.
+func skipLayers(filename string, remote []string) (map[string]bool, int64, error) {
+	manifests, configs, err := readImageArchive(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	skip := make(map[string]bool)
+	keep := make(map[string]bool)
+	for i, m := range manifests {
+		diffIDs := configs[i].RootFS.DiffIDs
+		n := 0
+		for n < len(diffIDs) && n < len(remote) && diffIDs[n] == remote[n] {
+			n++
+		}
+		for j, layer := range m.Layers {
+			if j < n {
+				skip[layer] = true
+			} else {
+				keep[layer] = true
+			}
+		}
+	}
+	for layer := range keep {
+		delete(skip, layer)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	size := int64(0)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if !skip[hdr.Name] {
+			size += hdr.Size
+		}
+	}
+	return skip, size, nil
+}
+
+// filterTar copies the tar archive filename to w
+// without the entries named in skip.
+func filterTar(w io.Writer, filename string, skip map[string]bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if skip[hdr.Name] {
+			continue
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, tr)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readTarEntry returns the contents of the named
+// entry within the tar archive filename.
+func readTarEntry(filename, name string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == name {
+			return io.ReadAll(tr)
+		}
+	}
+	return nil, fmt.Errorf("Image archive entry '%s' does not exist.", name)
+}
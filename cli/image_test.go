@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSkipLayers(t *testing.T) {
+	filename := newTestImageArchive(t, []string{"sha256:a", "sha256:b", "sha256:c"})
+	tests := []struct {
+		remote []string
+		want   map[string]bool
+	}{
+		{nil, map[string]bool{}},
+		{[]string{"sha256:a", "sha256:b"}, map[string]bool{"0/layer.tar": true, "1/layer.tar": true}},
+		{[]string{"sha256:b", "sha256:c"}, map[string]bool{}},
+		{[]string{"sha256:a", "sha256:x", "sha256:c"}, map[string]bool{"0/layer.tar": true}},
+	}
+	for _, tt := range tests {
+		have, _, err := skipLayers(filename, tt.remote)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("skipLayers %v\nhave %v\nwant %v", tt.remote, have, tt.want)
+		}
+	}
+}
+
+func TestFilterTar(t *testing.T) {
+	filename := newTestImageArchive(t, []string{"sha256:a", "sha256:b"})
+	skip := map[string]bool{"0/layer.tar": true}
+	var buf bytes.Buffer
+	err := filterTar(&buf, filename, skip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have := make([]string, 0)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		have = append(have, hdr.Name)
+	}
+	want := []string{"1/layer.tar", "config.json", "manifest.json"}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("filterTar\nhave %v\nwant %v", have, want)
+	}
+}
+
+// newTestImageArchive returns the filename of a docker save
+// archive with one layer file per diff ID.
+func newTestImageArchive(t *testing.T, diffIDs []string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(name string, b []byte) {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b))})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = tw.Write(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	layers := make([]string, len(diffIDs))
+	for i, diffID := range diffIDs {
+		layers[i] = string(rune('0'+i)) + "/layer.tar"
+		write(layers[i], []byte(diffID))
+	}
+	config := map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	write("config.json", b)
+	manifest := []imageManifest{{Config: "config.json", RepoTags: []string{"test:latest"}, Layers: layers}}
+	b, err = json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	write("manifest.json", b)
+	err = tw.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "image.tar")
+	err = os.WriteFile(filename, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return filename
+}
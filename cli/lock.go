@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pnelson/cli"
+)
+
+// lockFile is the advisory deploy lock on the machine. It
+// contains the JSON encoded lockHolder of the last holder.
+const lockFile = "/acrobox/deploy.lock"
+
+// lockBusy is the exit status of lockScript if the lock is busy.
+const lockBusy = 75
+
+// lockScript takes an exclusive flock on $1 and holds it until
+// stdin is closed, which happens when the session ends for any
+// reason. The current holder is written to stdout prefixed with
+// "held" if the lock is busy and the script exits with status 75
+// unless $2 is "wait". The new holder is read from stdin once
+// "locked" has been written.
+const lockScript = `exec 9>>"$1" || exit 1
+if ! flock -n 9; then
+	printf 'held '; cat "$1"; echo
+	[ "$2" = wait ] || exit 75
+	flock 9
+fi
+echo locked
+IFS= read -r holder
+printf '%s\n' "$holder" > "$1"
+exec cat > /dev/null`
+
+// breakScript removes the lock file $1 if it is locked, after
+// writing its current holder to stdout. Later callers lock a new
+// file while the previous holder keeps its lock on the old one.
+const breakScript = `[ -f "$1" ] || exit 0
+if flock -n "$1" true; then exit 0; fi
+cat "$1"
+rm -f "$1"`
+
+// lockHolder represents the holder of the deploy lock.
+type lockHolder struct {
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+// String returns a human readable description of the holder.
+func (h lockHolder) String() string {
+	s := h.User
+	if h.Host != "" {
+		s += "@" + h.Host
+	}
+	if h.Command != "" {
+		s += fmt.Sprintf(" (%s)", h.Command)
+	}
+	if !h.Since.IsZero() {
+		s += fmt.Sprintf(" since %s%s", formatTime(h.Since.Local()), formatDuration(h.Since, time.Now()))
+	}
+	return s
+}
+
+// lock takes the advisory deploy lock on the machine for the duration
+// of the command and returns a function that releases it. The lock is
+// held open by a session such that it is released if abx exits or
+// the connection drops. Nested calls are no-ops.
+//
+// The session is on a connection of its own rather than the cached
+// connection, which is closed and redialed when it drops and would
+// otherwise release the lock while the command continues.
+//
+// If the lock is busy the holder is reported and an error returned
+// unless waiting is enabled.
+func (c *client) lock(command string) (func(), error) {
+	if c.locked {
+		return func() {}, nil
+	}
+	s, err := c.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.NewSession()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	mode := "nowait"
+	if c.flags.lock.wait {
+		mode = "wait"
+	}
+	err = session.Start(quote("sh", "-c", lockScript, "sh", lockFile, mode))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	var holder lockHolder
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "held ") {
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "held ")), &holder)
+			if c.flags.lock.wait {
+				c.step(colorWRN, "Waiting for the machine lock held by %s.", holder)
+			}
+			continue
+		}
+		if line == "locked" {
+			break
+		}
+		if err != nil {
+			werr := session.Wait()
+			s.Close()
+			eerr, ok := werr.(*ssh.ExitError)
+			if ok && eerr.ExitStatus() == lockBusy {
+				return nil, fmt.Errorf("Machine is locked by %s. Use -wait to wait for the lock or 'abx lock/break' to break it.", holder)
+			}
+			if werr == nil {
+				werr = err
+			}
+			return nil, fmt.Errorf("Unable to lock the machine: %v", werr)
+		}
+	}
+	holder = lockHolder{
+		User:    localUser(),
+		Command: command,
+		Since:   time.Now().UTC(),
+	}
+	holder.Host, _ = os.Hostname()
+	b, err := json.Marshal(holder)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	_, err = stdin.Write(append(b, '\n'))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	c.locked = true
+	c.verbose("Locked the machine for '%s'.", command)
+	unlock := func() {
+		stdin.Close()
+		go io.Copy(io.Discard, stdout)
+		session.Wait()
+		s.Close()
+		c.locked = false
+	}
+	return unlock, nil
+}
+
+func (c *client) lockBreak(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	if !c.flags.lock.force {
+		c.cli.Printf("Confirmation to break the deploy lock of '%s' is required.\n", c.flags.host)
+		c.cli.Printf("  The current holder will continue without the lock.\n")
+		err := c.promptToAgree()
+		if err != nil {
+			return err
+		}
+	}
+	stdout, stderr, err := c.run("sh", "-c", breakScript, "sh", lockFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	if len(strings.TrimSpace(string(stdout))) == 0 {
+		c.cli.Printf("Machine is not locked.\n")
+		return nil
+	}
+	var holder lockHolder
+	_ = json.Unmarshal(stdout, &holder)
+	c.cli.Printf("Broke the machine lock held by %s.\n", holder)
+	return nil
+}
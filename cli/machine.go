@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
@@ -14,56 +17,116 @@ import (
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-func (c *client) waitForMachine(id string) (*getMachineResponse, error) {
-	for n := 1; n < 30; n++ {
-		time.Sleep(time.Duration(n) * time.Second)
-		m, err := c.http.getMachine(id)
-		if err != nil {
-			return nil, err
+// initStateFile is the name of the local provisioning state
+// within the machine directory until init completes.
+const initStateFile = "init.json"
+
+// Steps of machine initialization in the order they complete.
+const (
+	initProvisioned = "provisioned"
+	initKnownHosts  = "known_hosts"
+	initSSH         = "ssh"
+	initAcrobox     = "acroboxd"
+	initService     = "service"
+)
+
+// initState represents the provisioning state of a machine.
+type initState struct {
+	ID            string               `json:"id"`
+	Done          map[string]time.Time `json:"done"`
+	RollbackError string               `json:"rollback_error,omitempty"`
+}
+
+// readInitState returns the provisioning state of the machine
+// or nil if there is no initialization in progress.
+func (c *client) readInitState() (*initState, error) {
+	filename := filepath.Join(c.config.Home, c.flags.host, initStateFile)
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
+		return nil, err
+	}
+	var state initState
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("Provisioning state '%s' is malformed: %v", filename, err)
+	}
+	if state.ID == "" {
+		return nil, fmt.Errorf("Provisioning state '%s' has no machine ID.", filename)
+	}
+	if state.Done == nil {
+		state.Done = make(map[string]time.Time)
+	}
+	return &state, nil
+}
+
+func (c *client) writeInitState(state *initState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.writeBytes(initStateFile, append(b, '\n'), 0600)
+}
+
+// sshDialTimeout is the timeout of each SSH connectivity attempt.
+const sshDialTimeout = 10 * time.Second
+
+func (c *client) waitForMachine(ctx context.Context, id string, timeout time.Duration) (*getMachineResponse, error) {
+	return c.pollMachine(ctx, id, "provisioning machine", timeout, func(m *getMachineResponse) error {
 		if m.IPv4 == "" {
-			continue
+			return errors.New("machine has no IPv4 address yet")
 		}
-		return m, nil
-	}
-	return nil, errors.New("Timeout exceeded while provisioning machine.")
+		return nil
+	})
 }
 
-func (c *client) waitForSSH(ipv4 string) error {
+// pollMachine polls the machine state from the service until
+// ready returns nil. Errors of the service are not retried.
+func (c *client) pollMachine(ctx context.Context, id, description string, timeout time.Duration, ready func(*getMachineResponse) error) (*getMachineResponse, error) {
+	var m *getMachineResponse
+	err := c.poll(ctx, description, timeout, func(ctx context.Context) error {
+		var err error
+		m, err = c.http.getMachine(id)
+		if err != nil {
+			return permanent(err)
+		}
+		return ready(m)
+	})
+	return m, err
+}
+
+func (c *client) waitForSSH(ctx context.Context, ipv4 string, timeout time.Duration) error {
 	addr := net.JoinHostPort(ipv4, c.flags.port)
-	for n := 1; n < 30; n++ {
-		conn, err := net.Dial("tcp", addr)
+	return c.poll(ctx, "waiting for SSH connectivity", timeout, func(ctx context.Context) error {
+		d := net.Dialer{Timeout: sshDialTimeout}
+		conn, err := d.DialContext(ctx, "tcp", addr)
 		if err != nil {
-			time.Sleep(time.Duration(n) * time.Second)
-			continue
+			return err
 		}
 		return conn.Close()
-	}
-	return errors.New("Timeout exceeded while waiting for SSH connectivity.")
+	})
 }
 
-func (c *client) waitForAcrobox() error {
-	for n := 1; n < 30; n++ {
-		_, _, err := c.run("docker container inspect -f {{.Id}} acroboxd")
-		if err != nil {
-			time.Sleep(time.Duration(n) * time.Second)
-			continue
+func (c *client) waitForAcrobox(ctx context.Context, timeout time.Duration) error {
+	return c.poll(ctx, "waiting for machine setup", timeout, func(ctx context.Context) error {
+		_, stderr, err := c.runContext(ctx, "docker container inspect -f {{.Id}} acroboxd")
+		if err != nil && len(stderr) > 0 {
+			return errors.New(strings.TrimSpace(string(stderr)))
 		}
-		return nil
-	}
-	return errors.New("Timeout exceeded while waiting for machine setup.")
+		return err
+	})
 }
 
-func (c *client) waitForService() error {
-	for n := 1; n < 30; n++ {
-		_, _, err := c.run("docker exec acroboxd acroboxd status")
-		if err != nil {
-			time.Sleep(time.Duration(n) * time.Second)
-			continue
+func (c *client) waitForService(ctx context.Context, timeout time.Duration) error {
+	return c.poll(ctx, "waiting for service setup", timeout, func(ctx context.Context) error {
+		_, stderr, err := c.runContext(ctx, "docker exec acroboxd acroboxd status")
+		if err != nil && len(stderr) > 0 {
+			return errors.New(strings.TrimSpace(string(stderr)))
 		}
-		return nil
-	}
-	return errors.New("Timeout exceeded while waiting for service setup.")
+		return err
+	})
 }
 
 func (c *client) writeBytes(name string, b []byte, perm os.FileMode) error {
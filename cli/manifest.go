@@ -0,0 +1,344 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pnelson/cli"
+)
+
+// machineConfigFile is the acroboxd configuration on the machine.
+const machineConfigFile = "/acrobox/config.json"
+
+// internalImagePrefix is the image namespace of the containers
+// managed by acroboxd itself. They are never planned or pruned.
+const internalImagePrefix = "acrobox/"
+
+// manifest represents the desired state of a machine as
+// described by an abx.json file.
+type manifest struct {
+	Containers  map[string]manifestContainer `json:"containers,omitempty"`
+	Environment map[string]map[string]string `json:"environment,omitempty"`
+	Databases   []string                     `json:"databases,omitempty"`
+}
+
+// manifestContainer represents a container configured with abx add.
+type manifestContainer struct {
+	Image   string   `json:"image"`
+	Command []string `json:"command,omitempty"`
+	Site    string   `json:"site,omitempty"`
+	Port    string   `json:"port,omitempty"`
+	Task    string   `json:"task,omitempty"`
+}
+
+// change represents a planned change and the acroboxd
+// commands, with their arguments, that converge it.
+type change struct {
+	action   byte // '+', '~', or '-'
+	kind     string
+	name     string
+	commands [][]string
+}
+
+// String returns the change as displayed by plan.
+func (ch change) String() string {
+	return fmt.Sprintf("%c %s %s", ch.action, ch.kind, ch.name)
+}
+
+func (c *client) plan(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	changes, err := c.planManifest()
+	if err != nil {
+		return err
+	}
+	c.printPlan(changes)
+	return nil
+}
+
+func (c *client) apply(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	unlock, err := c.lock("apply")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	changes, err := c.planManifest()
+	if err != nil {
+		return err
+	}
+	c.printPlan(changes)
+	return c.applyChanges(changes, c.flags.manifest.force)
+}
+
+// applyChanges runs the acroboxd commands of the changes in order.
+// Confirmation is required to change or remove anything unless forced.
+func (c *client) applyChanges(changes []change, force bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	destructive := false
+	for _, ch := range changes {
+		if ch.action != '+' {
+			destructive = true
+		}
+	}
+	if destructive && !force {
+		c.cli.Printf("Confirmation to change or remove configuration of '%s' is required.\n", c.flags.host)
+		c.cli.Printf("  Changed containers are removed and added again.\n")
+		c.cli.Printf("  Removed containers and databases cannot be restored.\n")
+		err := c.promptToAgree()
+		if err != nil {
+			return err
+		}
+	}
+	for _, ch := range changes {
+		c.step(colorINF, "%s", ch)
+		for _, command := range ch.commands {
+			err := c.acroboxd(command[0], command[1:])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planManifest returns the changes required to converge
+// the machine towards the manifest file.
+func (c *client) planManifest() ([]change, error) {
+	want, err := readManifest(c.flags.manifest.file)
+	if err != nil {
+		return nil, err
+	}
+	have, err := c.machineState()
+	if err != nil {
+		return nil, err
+	}
+	return planChanges(want, have, c.flags.manifest.prune), nil
+}
+
+func (c *client) printPlan(changes []change) {
+	if len(changes) == 0 {
+		c.cli.Printf("No changes. Machine '%s' matches '%s'.\n", c.flags.host, c.flags.manifest.file)
+		return
+	}
+	counts := make(map[byte]int)
+	for _, ch := range changes {
+		c.cli.Printf("%s\n", ch)
+		counts[ch.action]++
+	}
+	c.cli.Printf("\nPlan: %d to add, %d to change, %d to remove.\n", counts['+'], counts['~'], counts['-'])
+}
+
+// readManifest returns the manifest of the file.
+func readManifest(filename string) (manifest, error) {
+	var m manifest
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, fmt.Errorf("Manifest '%s' does not exist.", filename)
+		}
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return m, fmt.Errorf("Manifest '%s' is malformed: %v", filename, err)
+	}
+	for name, ct := range m.Containers {
+		if ct.Image == "" {
+			return m, fmt.Errorf("Manifest '%s' container '%s' requires an image.", filename, name)
+		}
+		if ct.Site != "" && ct.Task != "" {
+			return m, fmt.Errorf("Manifest '%s' container '%s' cannot be both a site and a task.", filename, name)
+		}
+	}
+	return m, nil
+}
+
+// machineState returns the current state of the machine
+// from its configuration file and database list.
+func (c *client) machineState() (manifest, error) {
+	var m manifest
+	stdout, stderr, err := c.run("cat", machineConfigFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return m, err
+	}
+	var config acroboxdConfig
+	err = json.Unmarshal(stdout, &config)
+	if err != nil {
+		return m, fmt.Errorf("Machine configuration '%s' is malformed: %v", machineConfigFile, err)
+	}
+	stdout, stderr, err = c.run("docker", "exec", "acroboxd", "acroboxd", "db/list", "-format", "json")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return m, err
+	}
+	databases := make([]acroboxdDatabase, 0)
+	err = json.Unmarshal(stdout, &databases)
+	if err != nil {
+		return m, err
+	}
+	m.Containers = make(map[string]manifestContainer)
+	for _, ct := range config.Containers {
+		m.Containers[ct.Name] = manifestContainer{
+			Image:   ct.Image,
+			Command: ct.Command,
+			Site:    ct.Site,
+			Port:    ct.Port,
+			Task:    ct.Task,
+		}
+	}
+	m.Environment = config.Environment
+	for _, db := range databases {
+		m.Databases = append(m.Databases, db.Name)
+	}
+	return m, nil
+}
+
+// planChanges returns the changes required to converge have towards
+// want, in the order they are to be applied. Databases are created
+// first and destroyed last such that containers never lose them.
+// Entries absent from want are only removed if pruning.
+//
+// Environment variables of an image are set, or deleted, with a single
+// command that is attached to the last change of the group.
+func planChanges(want, have manifest, prune bool) []change {
+	changes := make([]change, 0)
+	haveDatabases := make(map[string]bool)
+	for _, name := range have.Databases {
+		haveDatabases[name] = true
+	}
+	wantDatabases := make(map[string]bool)
+	for _, name := range want.Databases {
+		wantDatabases[name] = true
+		if !haveDatabases[name] {
+			changes = append(changes, change{'+', "database", name, [][]string{{"db/create", name}}})
+		}
+	}
+	for _, image := range sortedKeys(want.Environment) {
+		wantEnv := want.Environment[image]
+		haveEnv := have.Environment[image]
+		set := []string{"env/set", image}
+		for _, key := range sortedKeys(wantEnv) {
+			value, ok := haveEnv[key]
+			if ok && value == wantEnv[key] {
+				continue
+			}
+			action := byte('+')
+			if ok {
+				action = '~'
+			}
+			set = append(set, key+"="+wantEnv[key])
+			changes = append(changes, change{action, "env", image + " " + key, nil})
+		}
+		if len(set) > 2 {
+			changes[len(changes)-1].commands = [][]string{set}
+		}
+		if !prune {
+			continue
+		}
+		del := []string{"env/del", image}
+		for _, key := range sortedKeys(haveEnv) {
+			_, ok := wantEnv[key]
+			if !ok {
+				del = append(del, key)
+				changes = append(changes, change{'-', "env", image + " " + key, nil})
+			}
+		}
+		if len(del) > 2 {
+			changes[len(changes)-1].commands = [][]string{del}
+		}
+	}
+	if prune {
+		for _, image := range sortedKeys(have.Environment) {
+			_, ok := want.Environment[image]
+			if ok || strings.HasPrefix(image, internalImagePrefix) || len(have.Environment[image]) == 0 {
+				continue
+			}
+			changes = append(changes, change{'-', "env", image, [][]string{{"env/del", "-force", image}}})
+		}
+		for _, name := range sortedKeys(have.Containers) {
+			_, ok := want.Containers[name]
+			if ok || strings.HasPrefix(have.Containers[name].Image, internalImagePrefix) {
+				continue
+			}
+			changes = append(changes, change{'-', "container", name, [][]string{{"remove", "-force", name}}})
+		}
+	}
+	for _, name := range sortedKeys(want.Containers) {
+		ct := want.Containers[name]
+		current, ok := have.Containers[name]
+		if !ok {
+			changes = append(changes, change{'+', "container", name, [][]string{addArgs(name, ct)}})
+			continue
+		}
+		if sameContainer(ct, current) {
+			continue
+		}
+		commands := [][]string{{"remove", "-force", name}, addArgs(name, ct)}
+		changes = append(changes, change{'~', "container", name, commands})
+	}
+	if prune {
+		for _, name := range have.Databases {
+			if !wantDatabases[name] {
+				changes = append(changes, change{'-', "database", name, [][]string{{"db/destroy", "-force", name}}})
+			}
+		}
+	}
+	return changes
+}
+
+// addArgs returns the acroboxd add command for the container.
+func addArgs(name string, ct manifestContainer) []string {
+	args := []string{"add"}
+	if ct.Task != "" {
+		args = append(args, "-task", ct.Task)
+	}
+	if ct.Site != "" {
+		args = append(args, "-site", ct.Site)
+	}
+	if ct.Port != "" {
+		args = append(args, "-port", ct.Port)
+	}
+	args = append(args, name, ct.Image)
+	return append(args, ct.Command...)
+}
+
+// sameContainer reports whether the containers are configured alike.
+// Image tags are ignored and sites default to port 8080.
+func sameContainer(a, b manifestContainer) bool {
+	normalize := func(ct manifestContainer) manifestContainer {
+		ct.Image = imageName(ct.Image)
+		if ct.Site != "" && ct.Port == "" {
+			ct.Port = "8080"
+		}
+		if ct.Site == "" {
+			ct.Port = ""
+		}
+		if len(ct.Command) == 0 {
+			ct.Command = nil
+		}
+		return ct
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanChanges(t *testing.T) {
+	want := manifest{
+		Containers: map[string]manifestContainer{
+			"web":    {Image: "example.com", Site: "example.com"},
+			"worker": {Image: "example.com", Command: []string{"bin/worker", "-n", "2"}},
+			"report": {Image: "example.com", Task: "@daily", Command: []string{"bin/report"}},
+		},
+		Environment: map[string]map[string]string{
+			"example.com": {"A": "1", "B": "two words", "C": "3"},
+		},
+		Databases: []string{"example", "reports"},
+	}
+	have := manifest{
+		Containers: map[string]manifestContainer{
+			"web":      {Image: "example.com:latest", Site: "example.com", Port: "8080"},
+			"worker":   {Image: "example.com", Command: []string{"bin/worker"}},
+			"old":      {Image: "old.example.com"},
+			"acroboxd": {Image: "acrobox/acroboxd"},
+		},
+		Environment: map[string]map[string]string{
+			"example.com":      {"A": "1", "B": "2", "D": "4"},
+			"old.example.com":  {"X": "1"},
+			"acrobox/acroboxd": {"POSTGRES_PASSWORD": "secret"},
+		},
+		Databases: []string{"example", "legacy"},
+	}
+	tests := []struct {
+		prune bool
+		want  []string
+	}{
+		{
+			false,
+			[]string{
+				"+ database reports: db/create reports",
+				"~ env example.com B",
+				"+ env example.com C: env/set example.com B=two words C=3",
+				"+ container report: add -task @daily report example.com bin/report",
+				"~ container worker: remove -force worker, add worker example.com bin/worker -n 2",
+			},
+		},
+		{
+			true,
+			[]string{
+				"+ database reports: db/create reports",
+				"~ env example.com B",
+				"+ env example.com C: env/set example.com B=two words C=3",
+				"- env example.com D: env/del example.com D",
+				"- env old.example.com: env/del -force old.example.com",
+				"- container old: remove -force old",
+				"+ container report: add -task @daily report example.com bin/report",
+				"~ container worker: remove -force worker, add worker example.com bin/worker -n 2",
+				"- database legacy: db/destroy -force legacy",
+			},
+		},
+	}
+	for _, tt := range tests {
+		lines := make([]string, 0)
+		for _, ch := range planChanges(want, have, tt.prune) {
+			s := ch.String()
+			commands := make([]string, len(ch.commands))
+			for i, command := range ch.commands {
+				commands[i] = strings.Join(command, " ")
+			}
+			if len(commands) > 0 {
+				s += ": " + strings.Join(commands, ", ")
+			}
+			lines = append(lines, s)
+		}
+		if !reflect.DeepEqual(lines, tt.want) {
+			t.Errorf("planChanges prune=%t\nhave %q\nwant %q", tt.prune, lines, tt.want)
+		}
+	}
+	changes := planChanges(want, want, true)
+	if len(changes) != 0 {
+		t.Errorf("converged state should have no changes\nhave %v", changes)
+	}
+}
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pnelson/cli"
+)
+
+// migrationFile is the name of the local migration checkpoint
+// within the destination machine directory.
+const migrationFile = "migration.json"
+
+// migration represents the checkpoint of a machine migration.
+type migration struct {
+	From string               `json:"from"`
+	To   string               `json:"to"`
+	Done map[string]time.Time `json:"done"`
+}
+
+// resumableStep represents a named step of a resumable command.
+type resumableStep struct {
+	name string
+	fn   func() error
+}
+
+// migrate copies the images, data, databases, and configuration of
+// one machine to another. Completed steps are recorded in a local
+// checkpoint such that an interrupted migration resumes where it
+// left off when run again.
+func (c *client) migrate(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	if c.flags.migrate.from == "" || c.flags.migrate.to == "" {
+		return fmt.Errorf("Flags 'from' and 'to' are required.")
+	}
+	if c.flags.migrate.from == c.flags.migrate.to {
+		return fmt.Errorf("Machines 'from' and 'to' must be different.")
+	}
+	src := c.machine(c.flags.migrate.from)
+	defer src.close()
+	dst := c.machine(c.flags.migrate.to)
+	defer dst.close()
+	checkpoint, err := dst.readMigration(src.flags.host)
+	if err != nil {
+		return err
+	}
+	unlock, err := dst.lock("migrate from " + src.flags.host)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	have, err := src.machineState()
+	if err != nil {
+		return err
+	}
+	want := portableManifest(have)
+	steps := src.migrationSteps(dst, want)
+	for i, step := range steps {
+		_, ok := checkpoint.Done[step.name]
+		if ok {
+			c.verbose("Skipping %s, completed in a previous run.", step.name)
+			continue
+		}
+		c.step(colorINF, "Migrating %s (%d/%d).", step.name, i+1, len(steps))
+		err = step.fn()
+		if err != nil {
+			c.step(colorERR, "Unable to migrate %s: %v", step.name, err)
+			c.cli.Errorf("Run the command again to resume the migration.\n")
+			return cli.ErrExitFailure
+		}
+		checkpoint.Done[step.name] = time.Now().UTC()
+		err = dst.writeMigration(checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Remove(filepath.Join(dst.config.Home, dst.flags.host, migrationFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.step(colorINF, "Migration from '%s' to '%s' is complete.", src.flags.host, dst.flags.host)
+	return src.printDNSChanges(dst, want)
+}
+
+// migrationSteps returns the steps to migrate the manifest from the
+// machine to the destination machine. Databases are created and
+// restored before the containers that use them are configured.
+func (c *client) migrationSteps(dst *client, m manifest) []resumableStep {
+	steps := make([]resumableStep, 0)
+	for _, image := range manifestImages(m) {
+		image := image
+		steps = append(steps, resumableStep{"image " + image, func() error {
+			return c.migrateImage(dst, image)
+		}})
+		steps = append(steps, resumableStep{"data " + image, func() error {
+			return c.migrateData(dst, image)
+		}})
+	}
+	steps = append(steps, resumableStep{"release tasks", func() error {
+		tasks, err := c.readReleaseTasks()
+		if err != nil || len(tasks) == 0 {
+			return err
+		}
+		return dst.writeReleaseTasks(tasks)
+	}})
+	databases := manifest{Databases: m.Databases}
+	steps = append(steps, resumableStep{"databases", func() error {
+		have, err := dst.machineState()
+		if err != nil {
+			return err
+		}
+		return dst.applyChanges(planChanges(databases, have, false), dst.flags.migrate.force)
+	}})
+	for _, name := range m.Databases {
+		name := name
+		steps = append(steps, resumableStep{"database " + name, func() error {
+			return c.migrateDatabase(dst, name)
+		}})
+	}
+	steps = append(steps, resumableStep{"configuration", func() error {
+		have, err := dst.machineState()
+		if err != nil {
+			return err
+		}
+		return dst.applyChanges(planChanges(m, have, false), dst.flags.migrate.force)
+	}})
+	return steps
+}
+
+// migrateImage copies the latest image, if any, to the destination
+// machine and verifies that the image was copied intact.
+func (c *client) migrateImage(dst *client, image string) error {
+	digest, err := c.imageDigest(image)
+	if err != nil {
+		c.verbose("Image '%s' has not been deployed.", image)
+		return nil
+	}
+	err = c.transfer(dst, image)
+	if err != nil {
+		return err
+	}
+	loaded, err := dst.imageDigest(image)
+	if err != nil {
+		return err
+	}
+	if loaded != digest {
+		return fmt.Errorf("Image '%s' on '%s' is '%s' but '%s' was copied.", image, dst.flags.host, loaded, digest)
+	}
+	return nil
+}
+
+// migrateData streams the data directory of the image, if
+// any, to the same location on the destination machine.
+func (c *client) migrateData(dst *client, image string) error {
+	_, _, err := c.run("test", "-d", "/acrobox/"+image)
+	if err != nil {
+		c.verbose("Image '%s' has no data directory.", image)
+		return nil
+	}
+	source := []string{"tar", "-c", "-C", "/acrobox", "-f", "-", image}
+	destination := []string{"tar", "-x", "-p", "-C", "/acrobox", "-f", "-"}
+	return c.pipe(dst, "/acrobox/"+image, 0, source, destination)
+}
+
+// migrateDatabase streams a pg_dump of the database into pg_restore
+// on the destination machine. Existing objects are dropped first
+// such that the step can be retried.
+func (c *client) migrateDatabase(dst *client, name string) error {
+	source := []string{"docker", "exec", "-u", "postgres", "postgres", "pg_dump", "-Fc", name}
+	destination := []string{"docker", "exec", "-i", "-u", "postgres", "postgres", "pg_restore", "--clean", "--if-exists", "--exit-on-error", "-d", name}
+	return c.pipe(dst, "database "+name, 0, source, destination)
+}
+
+// printDNSChanges prints the DNS records of the sites to be
+// pointed at the destination machine.
+func (c *client) printDNSChanges(dst *client, m manifest) error {
+	from, err := c.getIPv4()
+	if err != nil {
+		return err
+	}
+	to, err := dst.getIPv4()
+	if err != nil {
+		return err
+	}
+	hosts := make(map[string]bool)
+	for _, ct := range m.Containers {
+		if ct.Site == "" {
+			continue
+		}
+		host := ct.Site
+		i := strings.Index(host, "/")
+		if i != -1 {
+			host = host[:i]
+		}
+		hosts[host] = true
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	c.cli.Printf("\nUpdate the following DNS records from %s to %s:\n", from, to)
+	for _, host := range sortedKeys(hosts) {
+		c.cli.Printf("  %s  A  %s\n", host, to)
+	}
+	return nil
+}
+
+// portableManifest returns the manifest without the internal
+// containers and environment such that it can be applied to
+// another machine.
+func portableManifest(m manifest) manifest {
+	rv := manifest{
+		Containers:  make(map[string]manifestContainer),
+		Environment: make(map[string]map[string]string),
+		Databases:   m.Databases,
+	}
+	for name, ct := range m.Containers {
+		if !strings.HasPrefix(ct.Image, internalImagePrefix) {
+			rv.Containers[name] = ct
+		}
+	}
+	for image, env := range m.Environment {
+		if !strings.HasPrefix(image, internalImagePrefix) {
+			rv.Environment[image] = env
+		}
+	}
+	return rv
+}
+
+// manifestImages returns the distinct image names
+// of the containers of the manifest in sorted order.
+func manifestImages(m manifest) []string {
+	images := make(map[string]bool)
+	for _, ct := range m.Containers {
+		images[imageName(ct.Image)] = true
+	}
+	rv := make([]string, 0, len(images))
+	for image := range images {
+		rv = append(rv, image)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// readMigration returns the migration checkpoint of the machine or
+// a new checkpoint if there is none.
+func (c *client) readMigration(from string) (*migration, error) {
+	m := &migration{From: from, To: c.flags.host, Done: make(map[string]time.Time)}
+	b, err := os.ReadFile(filepath.Join(c.config.Home, c.flags.host, migrationFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	if m.From != from {
+		return nil, fmt.Errorf("Machine '%s' has an incomplete migration from '%s'. Run 'abx migrate -from %s -to %s' to resume it first.", c.flags.host, m.From, m.From, c.flags.host)
+	}
+	if m.Done == nil {
+		m.Done = make(map[string]time.Time)
+	}
+	c.verbose("Resuming the migration from '%s' with %d steps complete.", from, len(m.Done))
+	return m, nil
+}
+
+func (c *client) writeMigration(m *migration) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.writeBytes(migrationFile, append(b, '\n'), 0600)
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrationCheckpoint(t *testing.T) {
+	home := t.TempDir()
+	c := &client{config: &Config{Home: home, Stdout: io.Discard, Stderr: io.Discard}}
+	c.flags.host = "new"
+	err := os.MkdirAll(filepath.Join(home, "new"), 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := c.readMigration("old")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Done) != 0 {
+		t.Fatalf("new migration should have no completed steps\nhave %v", m.Done)
+	}
+	m.Done["image example.com"] = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = c.writeMigration(m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	have, err := c.readMigration("old")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(have, m) {
+		t.Errorf("checkpoint\nhave %+v\nwant %+v", have, m)
+	}
+	_, err = c.readMigration("other")
+	if err == nil {
+		t.Errorf("checkpoint from another machine should fail")
+	}
+}
+
+func TestPortableManifest(t *testing.T) {
+	m := portableManifest(manifest{
+		Containers: map[string]manifestContainer{
+			"acroboxd": {Image: "acrobox/acroboxd"},
+			"web":      {Image: "example.com:latest"},
+			"worker":   {Image: "example.com"},
+			"api":      {Image: "registry.example.com:5000/api"},
+		},
+		Environment: map[string]map[string]string{
+			"acrobox/acroboxd": {"POSTGRES_PASSWORD": "secret"},
+			"example.com":      {"A": "1"},
+		},
+	})
+	images := manifestImages(m)
+	want := []string{"example.com", "registry.example.com:5000/api"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("images\nhave %v\nwant %v", images, want)
+	}
+	_, ok := m.Environment["acrobox/acroboxd"]
+	if ok {
+		t.Errorf("internal environment should not be portable")
+	}
+}
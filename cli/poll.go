@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Delays between polling attempts double from pollInitialDelay
+// up to pollMaxDelay with up to half of each delay as jitter.
+const (
+	pollInitialDelay = time.Second
+	pollMaxDelay     = 30 * time.Second
+)
+
+// clock represents the passage of time while polling.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the clock of the system.
+type systemClock struct{}
+
+// Now implements the clock interface.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements the clock interface.
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// permanentError represents a polling error that is not retried.
+type permanentError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// permanent wraps err such that polling stops with it.
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// interruptContext returns a context that is cancelled on SIGINT or
+// SIGTERM. The default signal behaviour is restored by stop.
+func interruptContext() (ctx context.Context, stop func()) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// poll calls fn until it returns nil or a permanent error, the timeout
+// is exceeded, or ctx is done. Failed attempts are reported with the
+// verbose flag and retried after an exponential backoff with jitter.
+// The description completes the sentence "Timeout exceeded while".
+func (c *client) poll(ctx context.Context, description string, timeout time.Duration, fn func(context.Context) error) error {
+	clock := c.clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	deadline := clock.Now().Add(timeout)
+	delay := pollInitialDelay
+	for n := 1; ; n++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perr permanentError
+		if errors.As(err, &perr) {
+			return perr.err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("Interrupted while %s.", description)
+		}
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			c.verbose("Attempt %d failed: %v", n, err)
+			return fmt.Errorf("Timeout exceeded after %s while %s.", timeout, description)
+		}
+		wait := jitter(delay)
+		if wait > remaining {
+			wait = remaining
+		}
+		c.verbose("Attempt %d failed: %v. Retrying in %s.", n, err, roundDuration(wait))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Interrupted while %s.", description)
+		case <-clock.After(wait):
+		}
+		delay *= 2
+		if delay > pollMaxDelay {
+			delay = pollMaxDelay
+		}
+	}
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock is a fake clock that advances when waited on.
+type testClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func newTestPollClient() (*client, *testClock) {
+	clock := &testClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	c := &client{config: &Config{Stdout: io.Discard, Stderr: io.Discard}, clock: clock}
+	return c, clock
+}
+
+func TestPoll(t *testing.T) {
+	c, clock := newTestPollClient()
+	n := 0
+	err := c.poll(context.Background(), "testing", time.Hour, func(ctx context.Context) error {
+		n++
+		if n < 8 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 8 {
+		t.Fatalf("attempts\nhave %d\nwant %d", n, 8)
+	}
+	delay := pollInitialDelay
+	for i, wait := range clock.waits {
+		if wait < delay/2 || wait > delay {
+			t.Errorf("wait %d\nhave %s\nwant between %s and %s", i, wait, delay/2, delay)
+		}
+		delay *= 2
+		if delay > pollMaxDelay {
+			delay = pollMaxDelay
+		}
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	c, clock := newTestPollClient()
+	start := clock.now
+	n := 0
+	err := c.poll(context.Background(), "testing", time.Minute, func(ctx context.Context) error {
+		n++
+		return errors.New("not yet")
+	})
+	if err == nil || !strings.Contains(err.Error(), "Timeout exceeded") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	elapsed := clock.now.Sub(start)
+	if elapsed != time.Minute {
+		t.Errorf("elapsed\nhave %s\nwant %s", elapsed, time.Minute)
+	}
+	if n != len(clock.waits)+1 {
+		t.Errorf("attempts should be made at the deadline\nhave %d\nwant %d", n, len(clock.waits)+1)
+	}
+}
+
+func TestPollStop(t *testing.T) {
+	c, clock := newTestPollClient()
+	want := errors.New("test")
+	err := c.poll(context.Background(), "testing", time.Hour, func(ctx context.Context) error {
+		return permanent(want)
+	})
+	if err != want {
+		t.Fatalf("permanent error\nhave %v\nwant %v", err, want)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err = c.poll(ctx, "testing", time.Hour, func(ctx context.Context) error {
+		n++
+		if n == 3 {
+			cancel()
+		}
+		return errors.New("not yet")
+	})
+	if err == nil || !strings.Contains(err.Error(), "Interrupted") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 || len(clock.waits) != 2 {
+		t.Errorf("cancelled poll should stop\nhave %d attempts and %d waits", n, len(clock.waits))
+	}
+}
+
+func TestWaitForMachine(t *testing.T) {
+	var n int32
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		view := getMachineResponse{ID: "test"}
+		if atomic.AddInt32(&n, 1) > 2 {
+			view.IPv4 = "127.0.0.1"
+		}
+		newTestHandler(t, http.StatusOK, view).ServeHTTP(w, req)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(fn))
+	defer ts.Close()
+	c, clock := newTestPollClient()
+	c.http = newService(ts.URL, "")
+	m, err := c.waitForMachine(context.Background(), "test", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.IPv4 != "127.0.0.1" || len(clock.waits) != 2 {
+		t.Errorf("machine should be provisioned on the third attempt\nhave %q after %d waits", m.IPv4, len(clock.waits))
+	}
+}
+
+func TestWaitForServiceInterrupt(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	ss.fake(t, "docker", "exec sleep 30")
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.waitForService(ctx, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "Interrupted") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("interrupted wait should not block on the running command")
+	}
+	// A machine that accepts connections without completing
+	// the SSH handshake should not block either.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, c.flags.port, _ = net.SplitHostPort(l.Addr().String())
+	c.close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = c.waitForAcrobox(ctx, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "Interrupted") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("interrupted wait should not block on the handshake")
+	}
+}
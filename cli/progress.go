@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// progressDelay is the duration to wait before reporting progress
+	// such that short transfers remain quiet unless verbose.
+	progressDelay = time.Second
+
+	// progressTermInterval is the redraw interval on a terminal.
+	progressTermInterval = 200 * time.Millisecond
+
+	// progressLineInterval is the interval between plain lines
+	// when stdout is not a terminal.
+	progressLineInterval = 5 * time.Second
+)
+
+// progress represents a transfer progress report of bytes
+// transferred, throughput, and estimated time remaining.
+type progress struct {
+	w     io.Writer
+	label string
+	total int64 // zero if unknown
+	n     int64 // accessed atomically
+	base  int64 // bytes transferred before start
+	start time.Time
+	term  bool
+	shown bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newProgress returns a new progress report written to stdout.
+// The caller must call Close when the transfer is complete.
+func (c *client) newProgress(label string, total, base int64) *progress {
+	p := &progress{
+		w:     c.config.Stdout,
+		label: label,
+		total: total,
+		n:     base,
+		base:  base,
+		start: time.Now(),
+		term:  isTerminal(c.config.Stdout),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	delay := progressDelay
+	if c.flags.verbose {
+		delay = 0
+	}
+	go p.run(delay)
+	return p
+}
+
+func (p *progress) run(delay time.Duration) {
+	defer close(p.done)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-p.stop:
+		return
+	case <-timer.C:
+	}
+	interval := progressLineInterval
+	if p.term {
+		interval = progressTermInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.render(false)
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *progress) render(final bool) {
+	n := atomic.LoadInt64(&p.n)
+	line := formatProgress(p.label, n-p.base, n, p.total, time.Since(p.start))
+	if p.term {
+		fmt.Fprintf(p.w, "\r\033[K%s", line)
+		if final {
+			fmt.Fprintf(p.w, "\n")
+		}
+	} else {
+		fmt.Fprintf(p.w, "%s\n", line)
+	}
+	p.shown = true
+}
+
+// Close stops reporting and renders the final
+// state if any progress has been reported.
+func (p *progress) Close() {
+	close(p.stop)
+	<-p.done
+	if p.shown {
+		p.render(true)
+	}
+}
+
+// reader returns r counting bytes read towards the progress.
+func (p *progress) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+// writer returns w counting bytes written towards the progress.
+func (p *progress) writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(&r.p.n, int64(n))
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	atomic.AddInt64(&w.p.n, int64(n))
+	return n, err
+}
+
+// formatProgress returns a progress line where delta is the number of
+// bytes transferred in elapsed and n is the total transferred so far.
+func formatProgress(label string, delta, n, total int64, elapsed time.Duration) string {
+	rate := float64(0)
+	if elapsed > 0 {
+		rate = float64(delta) / elapsed.Seconds()
+	}
+	s := fmt.Sprintf("%s  %s", label, formatBytes(n))
+	if total > 0 && n > total {
+		total = n
+	}
+	if total > 0 {
+		s += fmt.Sprintf(" / %s (%d%%)", formatBytes(total), n*100/total)
+	}
+	s += fmt.Sprintf("  %s/s", formatBytes(int64(rate)))
+	if total > 0 && n < total && rate > 0 {
+		eta := time.Duration(float64(total-n) / rate * float64(time.Second))
+		s += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
+
+// formatBytes returns n in human readable binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && terminal.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+	for n, want := range tests {
+		have := formatBytes(n)
+		if have != want {
+			t.Errorf("formatBytes(%d)\nhave '%s'\nwant '%s'", n, have, want)
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		delta, n, total int64
+		want            string
+	}{
+		{2048, 2048, 0, "test  2.0 KiB  1.0 KiB/s"},
+		{2048, 2048, 4096, "test  2.0 KiB / 4.0 KiB (50%)  1.0 KiB/s  ETA 2s"},
+		{1024, 4096, 4096, "test  4.0 KiB / 4.0 KiB (100%)  512 B/s"},
+	}
+	for _, tt := range tests {
+		have := formatProgress("test", tt.delta, tt.n, tt.total, 2*time.Second)
+		if have != tt.want {
+			t.Errorf("formatProgress\nhave '%s'\nwant '%s'", have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pnelson/cli"
+)
+
+// promote copies the latest image from one machine to another through
+// the local process and deploys it on the destination machine.
+func (c *client) promote(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	if c.flags.promote.from == "" || c.flags.promote.to == "" {
+		return fmt.Errorf("Flags 'from' and 'to' are required.")
+	}
+	if c.flags.promote.from == c.flags.promote.to {
+		return fmt.Errorf("Machines 'from' and 'to' must be different.")
+	}
+	image := imageName(args[0])
+	src := c.machine(c.flags.promote.from)
+	defer src.close()
+	dst := c.machine(c.flags.promote.to)
+	defer dst.close()
+	digest, err := src.imageDigest(image)
+	if err != nil {
+		return fmt.Errorf("Image '%s' does not exist on '%s'.", image, src.flags.host)
+	}
+	want := c.flags.promote.digest
+	if want != "" && digest != want && strings.TrimPrefix(digest, "sha256:") != strings.TrimPrefix(want, "sha256:") {
+		return fmt.Errorf("Image '%s' on '%s' is '%s', not '%s'.", image, src.flags.host, digest, want)
+	}
+	unlock, err := dst.lock("promote " + image)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	c.step(colorINF, "Promoting '%s' (%s) from '%s' to '%s'.", image, digest, src.flags.host, dst.flags.host)
+	err = src.transfer(dst, image)
+	if err != nil {
+		return err
+	}
+	loaded, err := dst.imageDigest(image)
+	if err != nil {
+		return err
+	}
+	if loaded != digest {
+		return fmt.Errorf("Image '%s' on '%s' is '%s' but '%s' was promoted.", image, dst.flags.host, loaded, digest)
+	}
+	return dst.release(image, []string{image})
+}
+
+// machine returns a copy of the client for the named machine.
+func (c *client) machine(host string) *client {
+	m := &client{config: c.config, cli: c.cli, http: c.http, flags: c.flags}
+	m.flags.host = host
+	return m
+}
+
+// transfer streams docker save of the latest image on the machine
+// into docker load on the destination machine.
+func (c *client) transfer(dst *client, image string) error {
+	stdout, _, err := c.run("docker", "image", "inspect", "-f", "{{.Size}}", image+":latest")
+	if err != nil {
+		return err
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(stdout)), 10, 64)
+	return c.pipe(dst, image, size, []string{"docker", "save", image + ":latest"}, []string{"docker", "load"})
+}
+
+// pipe streams the stdout of the source command on the machine into
+// the stdin of the destination command on the destination machine
+// through the local process. The destination error takes precedence
+// over any source error it causes.
+func (c *client) pipe(dst *client, label string, size int64, source, destination []string) error {
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		stderr, err := c.stream(nil, pw, source[0], source[1:]...)
+		if err != nil && len(stderr) > 0 {
+			err = fmt.Errorf("%s on '%s': %s", source[0], c.flags.host, strings.TrimSpace(string(stderr)))
+		}
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+	p := dst.newProgress(label, size, 0)
+	_, stderr, err := dst.runWithStdin(p.reader(pr), destination[0], destination[1:]...)
+	p.Close()
+	pr.CloseWithError(errStreamDone)
+	serr := <-errc
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		if serr != nil && !errors.Is(serr, errStreamDone) {
+			c.cli.Errorf("%v\n", serr)
+		}
+		return err
+	}
+	return serr
+}
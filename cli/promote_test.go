@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pnelson/cli"
+)
+
+// testDockerScript fakes the docker commands used to promote images.
+// Images are files in the state directory named by machine address,
+// docker commands are logged to the log file in the state directory,
+// and save or load fail if a fail-save or fail-load file exists.
+const testDockerScript = `state=%s
+echo "$MACHINE $*" >> "$state/log"
+image="$state/$MACHINE.image"
+case "$1 $2" in
+"image inspect")
+	[ -f "$image" ] || { echo "No such image" >&2; exit 1; }
+	case "$4" in
+	*Size*) wc -c < "$image" ;;
+	*) echo "sha256:$(sha256sum < "$image" | cut -d ' ' -f 1)" ;;
+	esac ;;
+"save "*)
+	[ -f "$state/fail-save" ] && { echo "save failed" >&2; exit 1; }
+	cat "$image" ;;
+"load "*)
+	[ -f "$state/fail-load" ] && { echo "load failed" >&2; exit 1; }
+	cat > "$image" ;;
+esac
+exit 0`
+
+func TestPromote(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	newTestMachine(t, home, ss.port, "staging", "127.0.0.1", privateHostKey)
+	newTestMachine(t, home, ss.port, "production", "127.0.0.2", privateHostKey)
+	var stderr bytes.Buffer
+	c.config.Stderr = &stderr
+	c.cli = cli.New(AppName, nil, nil, cli.Stdout(io.Discard), cli.Stderr(&stderr))
+	c.flags.promote.from = "staging"
+	c.flags.promote.to = "production"
+	state := filepath.Join(home, "docker")
+	err := os.MkdirAll(state, 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ss.fake(t, "docker", strings.Replace(testDockerScript, "%s", "'"+state+"'", 1))
+	err = os.WriteFile(filepath.Join(state, "127.0.0.1.image"), []byte("image"), 0660)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	log := func() string {
+		b, _ := os.ReadFile(filepath.Join(state, "log"))
+		os.Remove(filepath.Join(state, "log"))
+		return string(b)
+	}
+
+	c.flags.promote.digest = "sha256:0123456789ab"
+	err = c.promote([]string{"example.com"})
+	if err == nil || !strings.Contains(err.Error(), "not 'sha256:0123456789ab'") {
+		t.Fatalf("digest mismatch\nhave %v", err)
+	}
+	if strings.Contains(log(), "save") {
+		t.Errorf("image of another digest should not be transferred")
+	}
+	c.flags.promote.digest = ""
+
+	fail := func(name string) func() {
+		filename := filepath.Join(state, "fail-"+name)
+		err := os.WriteFile(filename, nil, 0660)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return func() { os.Remove(filename) }
+	}
+	restore := fail("save")
+	err = c.promote([]string{"example.com"})
+	restore()
+	if err == nil || !strings.Contains(err.Error(), "save failed") {
+		t.Fatalf("source error should be returned if the destination succeeds\nhave %v", err)
+	}
+	restoreSave, restoreLoad := fail("save"), fail("load")
+	stderr.Reset()
+	err = c.promote([]string{"example.com"})
+	restoreSave()
+	restoreLoad()
+	var eerr *ssh.ExitError
+	if !errors.As(err, &eerr) || !strings.Contains(stderr.String(), "load failed") {
+		t.Fatalf("destination error should take precedence\nhave %v\nstderr %q", err, stderr.String())
+	}
+	if strings.Contains(log(), "127.0.0.2 exec") {
+		t.Errorf("failed promote should not deploy")
+	}
+
+	err = c.promote([]string{"example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(state, "127.0.0.2.image"))
+	if err != nil || string(b) != "image" {
+		t.Fatalf("image should be loaded on the destination\nhave %q, %v", b, err)
+	}
+	have := log()
+	if !strings.Contains(have, "127.0.0.2 exec -i -t acroboxd acroboxd deploy example.com") {
+		t.Errorf("image should be deployed on the destination\nhave %s", have)
+	}
+	if strings.Contains(have, "127.0.0.1 exec") || strings.Contains(have, "127.0.0.1 tag") {
+		t.Errorf("image should not be deployed on the source\nhave %s", have)
+	}
+}
+
+// newTestMachine adds a machine named host at the loopback address
+// ip with the key pair of the default test machine.
+func newTestMachine(t *testing.T, home, port, host, ip string, privateHostKey ssh.Signer) {
+	t.Helper()
+	dir := filepath.Join(home, host)
+	err := os.MkdirAll(dir, 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"id_ed25519", "id_ed25519.pub"} {
+		b, err := os.ReadFile(filepath.Join(home, username, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), b, 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	files := map[string]string{
+		"IPv4":        ip,
+		"known_hosts": knownhosts.Line([]string{ip + ":" + port}, privateHostKey.PublicKey()),
+	}
+	for name, value := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAuthKey is the docker config auths key for Docker Hub.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// pullScript pulls the image reference $1 on the machine using the
+// docker config read from stdin and tags it as $2. The config is
+// written to a private temporary directory that is removed on exit.
+const pullScript = `set -e
+d=$(mktemp -d)
+trap 'rm -rf "$d"' EXIT
+cat > "$d/config.json"
+DOCKER_CONFIG="$d" docker pull "$1"
+docker tag "$1" "$2"`
+
+// dockerConfig represents the subset of the docker
+// client configuration file that is of interest to abx.
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+}
+
+// dockerAuth represents a registry credential.
+type dockerAuth struct {
+	Auth string `json:"auth,omitempty"`
+}
+
+// pullImage pulls the image reference from its registry on
+// the machine and tags it as the latest image name.
+//
+// Credentials for the registry are read from the local docker
+// config and sent over the session. They are never persisted
+// on the machine beyond the duration of the pull.
+func (c *client) pullImage(ref, name string) error {
+	key, auth, err := registryAuth(registryHost(ref))
+	if err != nil {
+		return err
+	}
+	config := dockerConfig{Auths: make(map[string]dockerAuth)}
+	if auth.Auth != "" {
+		config.Auths[key] = auth
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	stderr, err := c.stream(bytes.NewReader(b), c.config.Stdout, "sh", "-c", pullScript, "sh", ref, name+":latest")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	return nil
+}
+
+// registryHost returns the registry host of the image reference
+// or the empty string if the reference refers to Docker Hub.
+func registryHost(ref string) string {
+	i := strings.Index(ref, "/")
+	if i == -1 {
+		return ""
+	}
+	host := ref[:i]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return ""
+}
+
+// registryAuth returns the docker config auths key and credential
+// for the registry host from the local docker config, if any,
+// consulting credential helpers where configured.
+func registryAuth(host string) (string, dockerAuth, error) {
+	keys := []string{host, "https://" + host, "https://" + host + "/v1/"}
+	if host == "" || host == "docker.io" {
+		keys = []string{dockerHubAuthKey}
+	}
+	var config dockerConfig
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return keys[0], dockerAuth{}, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys[0], dockerAuth{}, nil
+		}
+		return "", dockerAuth{}, err
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return "", dockerAuth{}, err
+	}
+	helper := config.CredsStore
+	for _, key := range keys {
+		h, ok := config.CredHelpers[key]
+		if ok {
+			helper = h
+		}
+	}
+	for _, key := range keys {
+		auth, ok := config.Auths[key]
+		if ok && auth.Auth != "" {
+			return keys[0], auth, nil
+		}
+	}
+	if helper == "" {
+		return keys[0], dockerAuth{}, nil
+	}
+	auth, err := credentialHelperAuth(helper, keys[0])
+	return keys[0], auth, err
+}
+
+// credentialHelperAuth returns the credential for the
+// registry key from the docker credential helper.
+func credentialHelperAuth(helper, key string) (dockerAuth, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(key)
+	b, err := cmd.Output()
+	if err != nil {
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) && strings.Contains(string(b), "credentials not found") {
+			return dockerAuth{}, nil
+		}
+		return dockerAuth{}, fmt.Errorf("docker-credential-%s: %v", helper, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	err = json.Unmarshal(b, &creds)
+	if err != nil {
+		return dockerAuth{}, err
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Secret))
+	return dockerAuth{Auth: auth}, nil
+}
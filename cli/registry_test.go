@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageName(t *testing.T) {
+	tests := map[string]string{
+		"app":                             "app",
+		"app:1.2":                         "app",
+		"acme/app@sha256:abc":             "acme/app",
+		"ghcr.io/acme/app:latest":         "ghcr.io/acme/app",
+		"localhost:5000/app":              "localhost:5000/app",
+		"localhost:5000/app:1.2@sha256:a": "localhost:5000/app",
+	}
+	for ref, want := range tests {
+		have := imageName(ref)
+		if have != want {
+			t.Errorf("imageName(%s)\nhave '%s'\nwant '%s'", ref, have, want)
+		}
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"app":                     "",
+		"acme/app":                "",
+		"ghcr.io/acme/app:latest": "ghcr.io",
+		"localhost/app":           "localhost",
+		"localhost:5000/app":      "localhost:5000",
+	}
+	for ref, want := range tests {
+		have := registryHost(ref)
+		if have != want {
+			t.Errorf("registryHost(%s)\nhave '%s'\nwant '%s'", ref, have, want)
+		}
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	config := `{"auths":{"https://ghcr.io":{"auth":"dGVzdDp0ZXN0"},"https://index.docker.io/v1/":{"auth":"aHViOmh1Yg=="}}}`
+	err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prev, ok := os.LookupEnv("DOCKER_CONFIG")
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer func() {
+		if ok {
+			os.Setenv("DOCKER_CONFIG", prev)
+		} else {
+			os.Unsetenv("DOCKER_CONFIG")
+		}
+	}()
+	tests := []struct {
+		host, key, auth string
+	}{
+		{"ghcr.io", "ghcr.io", "dGVzdDp0ZXN0"},
+		{"", dockerHubAuthKey, "aHViOmh1Yg=="},
+		{"example.com", "example.com", ""},
+	}
+	for _, tt := range tests {
+		key, auth, err := registryAuth(tt.host)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if key != tt.key || auth.Auth != tt.auth {
+			t.Errorf("registryAuth(%s)\nhave '%s' '%s'\nwant '%s' '%s'", tt.host, key, auth.Auth, tt.key, tt.auth)
+		}
+	}
+}
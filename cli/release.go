@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pnelson/cli"
+)
+
+// releaseFile is the release task configuration on the machine
+// as a JSON object of image names to command arguments.
+const releaseFile = "/acrobox/releases.json"
+
+// runReleaseTask runs the release task of the image, if any, as a
+// one-off container from the latest image before its containers
+// are restarted. The -release flag takes precedence over the
+// release task configured for the image.
+func (c *client) runReleaseTask(image string) error {
+	command, err := splitArgs(c.flags.deploy.release)
+	if err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		tasks, err := c.readReleaseTasks()
+		if err != nil {
+			return err
+		}
+		command = tasks[image]
+	}
+	if len(command) == 0 {
+		return nil
+	}
+	c.step(colorINF, "Running release task '%s'.", strings.Join(command, " "))
+	err = c.acroboxd("run", append([]string{image}, command...))
+	if err != nil {
+		return fmt.Errorf("Release task for '%s' failed. The deploy has been aborted.", image)
+	}
+	return nil
+}
+
+func (c *client) releaseSet(args []string) error {
+	if len(args) < 2 {
+		return cli.ErrUsage
+	}
+	tasks, err := c.readReleaseTasks()
+	if err != nil {
+		return err
+	}
+	tasks[imageName(args[0])] = args[1:]
+	return c.writeReleaseTasks(tasks)
+}
+
+func (c *client) releaseGet(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	tasks, err := c.readReleaseTasks()
+	if err != nil {
+		return err
+	}
+	command, ok := tasks[imageName(args[0])]
+	if !ok {
+		return fmt.Errorf("Image '%s' has no release task.", args[0])
+	}
+	c.cli.Printf("%s\n", quote("", command...))
+	return nil
+}
+
+func (c *client) releaseDel(args []string) error {
+	if len(args) != 1 {
+		return cli.ErrUsage
+	}
+	tasks, err := c.readReleaseTasks()
+	if err != nil {
+		return err
+	}
+	delete(tasks, imageName(args[0]))
+	return c.writeReleaseTasks(tasks)
+}
+
+// readReleaseTasks returns the release tasks configured on the machine.
+func (c *client) readReleaseTasks() (map[string][]string, error) {
+	stdout, stderr, err := c.run("sh", "-c", `if [ -f "$1" ]; then cat "$1"; fi`, "sh", releaseFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return nil, err
+	}
+	tasks := make(map[string][]string)
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		return tasks, nil
+	}
+	err = json.Unmarshal(stdout, &tasks)
+	if err != nil {
+		return nil, fmt.Errorf("Release task configuration '%s' is malformed: %v", releaseFile, err)
+	}
+	return tasks, nil
+}
+
+// writeReleaseTasks replaces the release tasks configured on the machine.
+func (c *client) writeReleaseTasks(tasks map[string][]string) error {
+	b, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, stderr, err := c.runWithStdin(bytes.NewReader(b), "sh", "-c", `cat > "$1.tmp" && mv "$1.tmp" "$1"`, "sh", releaseFile)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	return nil
+}
+
+// splitArgs splits s into arguments as a POSIX shell would
+// without expansions. Single and double quotes group words
+// and backslashes escape the following character outside
+// of single quotes. An escaped newline continues the line.
+func splitArgs(s string) ([]string, error) {
+	args := make([]string, 0)
+	var b strings.Builder
+	word := false
+	var q byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case q == '\'':
+			if ch == '\'' {
+				q = 0
+			} else {
+				b.WriteByte(ch)
+			}
+		case ch == '\\' && (q == 0 || q == '"'):
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("Command '%s' ends with an escape.", s)
+			}
+			i++
+			if s[i] == '\n' {
+				continue // line continuation
+			}
+			if q == '"' && !strings.ContainsRune("\\\"$`", rune(s[i])) {
+				b.WriteByte(ch)
+			}
+			b.WriteByte(s[i])
+			word = true
+		case q == '"':
+			if ch == '"' {
+				q = 0
+			} else {
+				b.WriteByte(ch)
+			}
+		case ch == '\'' || ch == '"':
+			q = ch
+			word = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if word {
+				args = append(args, b.String())
+				b.Reset()
+				word = false
+			}
+		default:
+			b.WriteByte(ch)
+			word = true
+		}
+	}
+	if q != 0 {
+		return nil, fmt.Errorf("Command '%s' has an unterminated quote.", s)
+	}
+	if word {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := map[string][]string{
+		"":                              {},
+		"  ":                            {},
+		"rails db:migrate":              {"rails", "db:migrate"},
+		"  migrate   -path  /m  ":       {"migrate", "-path", "/m"},
+		`sh -c 'echo "a b"; exit 1'`:    {"sh", "-c", `echo "a b"; exit 1`},
+		`echo "it's" "" x`:              {"echo", "it's", "", "x"},
+		`echo a\ b "c\"d" "e\f"`:        {"echo", "a b", `c"d`, `e\f`},
+		`echo 'a'"b"c`:                  {"echo", "abc"},
+		"migrate\tup\n":                 {"migrate", "up"},
+		`echo '\'`:                      {"echo", `\`},
+		`bin/task --name="release 1"`:   {"bin/task", "--name=release 1"},
+		`x ""`:                          {"x", ""},
+		`x ''`:                          {"x", ""},
+		`a\\b`:                          {`a\b`},
+		`"$HOME"`:                       {"$HOME"},
+		`one\` + "\n" + `two`:           {"onetwo"},
+		`"one\` + "\n" + `two"`:         {"onetwo"},
+		`one \` + "\n" + `  two`:        {"one", "two"},
+		`'one\` + "\n" + `two'`:         {"one\\\ntwo"},
+		`--flag="a"'b'`:                 {"--flag=ab"},
+		`'don'\''t'`:                    {"don't"},
+		`bundle exec rake db:migrate`:   {"bundle", "exec", "rake", "db:migrate"},
+		`./migrate -database "$URL" up`: {"./migrate", "-database", "$URL", "up"},
+	}
+	for s, want := range tests {
+		have, err := splitArgs(s)
+		if err != nil {
+			t.Fatalf("splitArgs(%q) unexpected error: %v", s, err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("splitArgs(%q)\nhave %q\nwant %q", s, have, want)
+		}
+	}
+	for _, s := range []string{`echo 'a`, `echo "a`, `echo a\`} {
+		_, err := splitArgs(s)
+		if err == nil {
+			t.Errorf("splitArgs(%q) should fail", s)
+		}
+	}
+}
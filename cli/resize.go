@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pnelson/cli"
+)
+
+// growImage is the image of the privileged helper container that
+// grows the filesystem. The machine runs commands as an unprivileged
+// user and its base system lacks the filesystem tools.
+const growImage = "alpine:3"
+
+// growScript grows the filesystem of type $2 on device $1
+// mounted at /acrobox to the size of its block device.
+const growScript = `case "$2" in
+xfs) apk add -q --no-cache xfsprogs-extra && xfs_growfs /acrobox ;;
+*) apk add -q --no-cache e2fsprogs-extra && resize2fs "$1" ;;
+esac`
+
+// resize changes the droplet size of the machine. The machine is
+// powered off while resizing and is ready once the service is up.
+func (c *client) resize(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	size := c.flags.resize.Size
+	if size == "" {
+		return fmt.Errorf("Flag 'size' is required.")
+	}
+	id, err := c.getID()
+	if err != nil {
+		return err
+	}
+	m, err := c.http.getMachine(id)
+	if err != nil {
+		return err
+	}
+	if m.Size == size {
+		c.cli.Printf("Machine '%s' is already size '%s'.\n", c.flags.host, size)
+		return nil
+	}
+	if !c.flags.resize.force {
+		c.cli.Printf("Machine '%s' will be resized from '%s' to '%s'.\n", c.flags.host, m.Size, size)
+		c.cli.Printf("  The machine is powered off while resizing.\n")
+		c.cli.Printf("Payment authorization required.\n")
+		c.cli.Printf(cardAuthText)
+		err = c.promptToAgree()
+		if err != nil {
+			return err
+		}
+	}
+	c.step(colorINF, "Resizing machine '%s' to '%s'.", c.flags.host, size)
+	err = c.http.resizeMachine(id, c.flags.resize)
+	if err != nil {
+		return err
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	timeout := c.flags.resize.timeout
+	m, err = c.pollMachine(ctx, id, "resizing machine", timeout, func(m *getMachineResponse) error {
+		if m.Size != size {
+			return fmt.Errorf("machine is size '%s'", m.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.step(colorINF, "Waiting for SSH connectivity.")
+	err = c.waitForSSH(ctx, m.IPv4, timeout)
+	if err != nil {
+		return err
+	}
+	c.step(colorINF, "Waiting for service setup.")
+	err = c.waitForService(ctx, timeout)
+	if err != nil {
+		return err
+	}
+	c.step(colorINF, "Machine '%s' is now size '%s'.", c.flags.host, size)
+	return nil
+}
+
+// volumeResize grows the data volume of the machine and
+// then grows its filesystem to match over SSH. The filesystem
+// is grown if the volume is already the size as a previous
+// attempt may have failed after resizing the volume.
+func (c *client) volumeResize(args []string) error {
+	if len(args) > 0 {
+		return cli.ErrUsage
+	}
+	size := c.flags.volume.DataSize
+	if size < 1 {
+		return fmt.Errorf("Flag 'data-size' is required.")
+	}
+	id, err := c.getID()
+	if err != nil {
+		return err
+	}
+	m, err := c.http.getMachine(id)
+	if err != nil {
+		return err
+	}
+	if m.DataSize == size {
+		c.cli.Printf("Volume of machine '%s' is already %dGB.\n", c.flags.host, size)
+		c.step(colorINF, "Growing filesystem.")
+		return c.growFilesystem()
+	}
+	if m.DataSize > size {
+		return fmt.Errorf("Volume of machine '%s' is %dGB. Volumes can only grow.", c.flags.host, m.DataSize)
+	}
+	if !c.flags.volume.force {
+		c.cli.Printf("Volume of machine '%s' will grow from %dGB to %dGB.\n", c.flags.host, m.DataSize, size)
+		c.cli.Printf("  Volumes cannot be shrunk later.\n")
+		c.cli.Printf("Payment authorization required.\n")
+		c.cli.Printf(cardAuthText)
+		err = c.promptToAgree()
+		if err != nil {
+			return err
+		}
+	}
+	c.step(colorINF, "Resizing volume of machine '%s' to %dGB.", c.flags.host, size)
+	err = c.http.resizeVolume(id, c.flags.volume)
+	if err != nil {
+		return err
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	_, err = c.pollMachine(ctx, id, "resizing volume", c.flags.volume.timeout, func(m *getMachineResponse) error {
+		if m.DataSize != size {
+			return fmt.Errorf("volume is %dGB", m.DataSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.step(colorINF, "Growing filesystem.")
+	return c.growFilesystem()
+}
+
+// growFilesystem grows the filesystem of the data volume
+// to the size of its block device.
+func (c *client) growFilesystem() error {
+	mounts, stderr, err := c.run("cat", "/proc/mounts")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	dev, fstype, err := findMount(string(mounts), "/acrobox")
+	if err != nil {
+		return err
+	}
+	c.verbose("Growing %s filesystem on '%s'.", fstype, dev)
+	_, stderr, err = c.run("docker", "run", "--rm", "--privileged", "-v", "/dev:/dev", "-v", "/acrobox:/acrobox", growImage, "sh", "-c", growScript, "sh", dev, fstype)
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	stdout, stderr, err := c.run("df", "-h", "/acrobox")
+	if err != nil {
+		c.cli.Errorf("%s\n", stderr)
+		return err
+	}
+	c.verbose("%s", strings.TrimSpace(string(stdout)))
+	return nil
+}
+
+// findMount returns the device and filesystem type mounted at target
+// in the /proc/mounts format. The last mount wins as it hides the
+// others mounted at the same target.
+func findMount(mounts, target string) (dev, fstype string, err error) {
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != target {
+			continue
+		}
+		dev, fstype = fields[0], fields[2]
+	}
+	if dev == "" {
+		return "", "", fmt.Errorf("Nothing is mounted at '%s'.", target)
+	}
+	return dev, fstype, nil
+}
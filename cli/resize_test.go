@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	privateHostKey, _ := newTestHostKeyPair(t)
+	var resized, grown int32
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		view := getMachineResponse{ID: "test", IPv4: "127.0.0.1", Size: "s-1vcpu-1gb-intel", DataSize: 5}
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/machines/test/resize":
+			atomic.AddInt32(&resized, 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case req.Method == http.MethodPost && req.URL.Path == "/machines/test/volume/resize":
+			atomic.AddInt32(&grown, 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if atomic.LoadInt32(&resized) > 0 {
+			view.Size = "s-2vcpu-4gb"
+		}
+		if atomic.LoadInt32(&grown) > 0 {
+			view.DataSize = 10
+		}
+		newTestHandler(t, http.StatusOK, view).ServeHTTP(w, req)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(fn))
+	defer ts.Close()
+	home := t.TempDir()
+	ss := newTestSSH(t, home, privateHostKey)
+	c := newTestClient(t, home, ss.port, privateHostKey)
+	err := c.writeString("ID", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	run := func(args ...string) error {
+		config := &Config{
+			Args:   append([]string{"abx", "-addr", ts.URL, "-port", ss.port}, args...),
+			Home:   home,
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+		}
+		return Run(config)
+	}
+	err = run("resize", "-force", "-size", "s-2vcpu-4gb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&resized) != 1 {
+		t.Fatalf("machine should be resized once")
+	}
+	err = run("resize", "-force", "-size", "s-2vcpu-4gb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&resized) != 1 {
+		t.Fatalf("machine of the same size should not be resized")
+	}
+	err = run("volume/resize", "-force", "-data-size", "1")
+	if err == nil {
+		t.Fatalf("volume should not shrink")
+	}
+	ss.fake(t, "cat", `echo "/dev/vda1 / ext4 rw,relatime 0 0"
+echo "/dev/sda /acrobox ext4 rw,relatime 0 0"`)
+	ss.fake(t, "docker", `printf '%s\n' "$@" > `+filepath.Join(home, "docker.args"))
+	ss.fake(t, "df", `echo "/dev/sda 9.8G 24K 9.3G 1% /acrobox"`)
+	err = run("volume/resize", "-force", "-data-size", "10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(home, "docker.args"))
+	if err != nil {
+		t.Fatalf("filesystem should be grown: %v", err)
+	}
+	args := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if args[0] != "run" || args[2] != "--privileged" {
+		t.Errorf("filesystem should be grown in a privileged container\nhave %q", args)
+	}
+	want := []string{"sh", "/dev/sda", "ext4"}
+	have := args[len(args)-len(want):]
+	if strings.Join(have, " ") != strings.Join(want, " ") {
+		t.Errorf("grow arguments\nhave %q\nwant %q", have, want)
+	}
+	err = os.Remove(filepath.Join(home, "docker.args"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = run("volume/resize", "-force", "-data-size", "10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&grown) != 1 {
+		t.Fatalf("volume of the same size should not be resized")
+	}
+	_, err = os.Stat(filepath.Join(home, "docker.args"))
+	if err != nil {
+		t.Errorf("filesystem of a volume of the same size should be grown: %v", err)
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts := `/dev/vda1 / ext4 rw,relatime 0 0
+/dev/sda /acrobox ext4 rw,relatime 0 0
+/dev/sdb /acrobox xfs rw,relatime 0 0
+/dev/sdc /acrobox/data ext4 rw,relatime 0 0
+`
+	dev, fstype, err := findMount(mounts, "/acrobox")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dev != "/dev/sdb" || fstype != "xfs" {
+		t.Errorf("findMount\nhave %s %s\nwant %s %s", dev, fstype, "/dev/sdb", "xfs")
+	}
+	_, _, err = findMount(mounts, "/missing")
+	if err == nil {
+		t.Errorf("findMount of unmounted target should fail")
+	}
+}
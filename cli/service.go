@@ -3,6 +3,7 @@ package cli
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
@@ -64,11 +65,11 @@ func (c *service) parseRequest(method, path string, form, view interface{}) erro
 		return err
 	}
 	defer resp.Body.Close()
-	if view == nil {
-		return nil
-	}
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		if view == nil {
+			return nil
+		}
 		return json.NewDecoder(resp.Body).Decode(&view)
 	case http.StatusNoContent:
 		return nil
@@ -76,7 +77,7 @@ func (c *service) parseRequest(method, path string, form, view interface{}) erro
 	var verr errorResponse
 	err = json.NewDecoder(resp.Body).Decode(&verr)
 	if err != nil {
-		return err
+		return fmt.Errorf("Service responded with '%s'.", resp.Status)
 	}
 	return verr
 }
@@ -100,16 +101,36 @@ func (c *service) renewMachine(id string) error {
 	return c.parseRequest(http.MethodPost, "/machines/"+id+"/renew", nil, nil)
 }
 
+func (c *service) resizeMachine(id string, form flagsResize) error {
+	return c.parseRequest(http.MethodPost, "/machines/"+id+"/resize", form, nil)
+}
+
+func (c *service) resizeVolume(id string, form flagsVolumeResize) error {
+	return c.parseRequest(http.MethodPost, "/machines/"+id+"/volume/resize", form, nil)
+}
+
 func (c *service) destroyMachine(id string, form flagsDestroy) error {
 	return c.parseRequest(http.MethodDelete, "/machines/"+id, form, nil)
 }
 
+func (c *service) getRegions() ([]catalogRegion, error) {
+	var view []catalogRegion
+	return view, c.parseRequest(http.MethodGet, "/regions", nil, &view)
+}
+
+func (c *service) getSizes() ([]catalogSize, error) {
+	var view []catalogSize
+	return view, c.parseRequest(http.MethodGet, "/sizes", nil, &view)
+}
+
 type getMachineResponse struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	Name      string    `json:"name"`
 	IPv4      string    `json:"ipv4"`
 	PublicKey string    `json:"public_key"`
+	Size      string    `json:"size"`
+	DataSize  int       `json:"data_size"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
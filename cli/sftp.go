@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pkg/sftp"
+)
+
+// uploadAttempts is the number of times an upload is
+// attempted before giving up on a dropped connection.
+const uploadAttempts = 3
+
+// sftpClient returns the cached SFTP client, starting the
+// SFTP subsystem on the cached connection on first use.
+func (c *client) sftpClient() (*sftp.Client, error) {
+	c.conn.mu.Lock()
+	sc := c.conn.sftp
+	c.conn.mu.Unlock()
+	if sc != nil {
+		return sc, nil
+	}
+	fn := func(s *ssh.Client) error {
+		var err error
+		sc, err = sftp.NewClient(s)
+		return err
+	}
+	err := c.connect(fn)
+	if err != nil {
+		return nil, err
+	}
+	c.conn.mu.Lock()
+	c.conn.sftp = sc
+	c.conn.mu.Unlock()
+	return sc, nil
+}
+
+// resetSFTP closes the cached SFTP client such that
+// the next call to sftpClient starts a new one.
+func (c *client) resetSFTP() {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.conn.sftp != nil {
+		c.conn.sftp.Close()
+		c.conn.sftp = nil
+	}
+}
+
+// upload copies f to the remote target with the given mode.
+//
+// Data is written to a .partial file alongside the target. If the
+// partial file already exists from an interrupted upload then the
+// upload resumes from its size. The SHA-256 checksum of the partial
+// file is verified against the local file before it is renamed
+// into place.
+func (c *client) upload(f *os.File, target string, mode os.FileMode) error {
+	partial := target + ".partial"
+	var err error
+	for n := 1; n <= uploadAttempts; n++ {
+		err = c.uploadPartial(f, partial)
+		if err == nil {
+			break
+		}
+		_, ok := err.(*sftp.StatusError)
+		if ok || os.IsNotExist(err) || os.IsPermission(err) {
+			return err
+		}
+		c.resetSFTP()
+	}
+	if err != nil {
+		return err
+	}
+	want, err := sha256File(f)
+	if err != nil {
+		return err
+	}
+	have, err := c.sha256Remote(partial)
+	if err != nil {
+		return err
+	}
+	sc, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+	if have != want {
+		sc.Remove(partial)
+		return fmt.Errorf("Checksum mismatch for '%s' (local %s, remote %s). Run the command again to retry.", target, want, have)
+	}
+	err = sc.Chmod(partial, mode)
+	if err != nil {
+		return err
+	}
+	return sc.PosixRename(partial, target)
+}
+
+// uploadPartial appends the remainder of f to the remote
+// partial file starting from the size of the partial file.
+func (c *client) uploadPartial(f *os.File, partial string) error {
+	sc, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := int64(0)
+	rfi, err := sc.Stat(partial)
+	if err == nil && rfi.Size() <= fi.Size() {
+		offset = rfi.Size()
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	} else {
+		c.verbose("Resuming upload of '%s' at %d bytes.", partial, offset)
+	}
+	w, err := sc.OpenFile(partial, flags)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	p := c.newProgress(fi.Name(), fi.Size(), offset)
+	defer p.Close()
+	_, err = w.ReadFrom(p.reader(f))
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// sha256Remote returns the hex-encoded SHA-256 checksum
+// of the remote file as computed by sha256sum.
+func (c *client) sha256Remote(filename string) (string, error) {
+	stdout, _, err := c.run("sha256sum", filename)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(stdout))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Unexpected sha256sum output for '%s'.", filename)
+	}
+	return fields[0], nil
+}
+
+// sha256File returns the hex-encoded SHA-256 checksum of f.
+func sha256File(f *os.File) (string, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// pullFile streams the remote source file into a temporary file
+// in the target directory which is then synced and renamed into place.
+func (c *client) pullFile(sc *sftp.Client, source, target string) error {
+	r, err := sc.Open(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".abx-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	p := c.newProgress(fi.Name(), fi.Size(), 0)
+	_, err = r.WriteTo(p.writer(f))
+	p.Close()
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), target)
+}
@@ -2,19 +2,98 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/pkg/sftp"
 )
 
 const term = "xterm-256color"
 
+// conn represents a cached SSH connection to the machine.
+type conn struct {
+	mu     sync.Mutex
+	client *ssh.Client
+	sftp   *sftp.Client
+	direct bool // skip the agent
+}
+
+// newSession returns a new session on the cached connection.
 func (c *client) newSession() (*ssh.Session, error) {
+	var session *ssh.Session
+	fn := func(s *ssh.Client) error {
+		var err error
+		session, err = s.NewSession()
+		return err
+	}
+	return session, c.connect(fn)
+}
+
+// dialMachine returns a connection to addr as dialed from the
+// machine by way of a direct-tcpip channel on the cached connection.
+func (c *client) dialMachine(network, addr string) (net.Conn, error) {
+	var conn net.Conn
+	fn := func(s *ssh.Client) error {
+		var err error
+		conn, err = s.Dial(network, addr)
+		return err
+	}
+	return conn, c.connect(fn)
+}
+
+// connect calls fn with the cached connection. The connection is
+// established on first use and redialed once if it has since been
+// dropped. Channels rejected by the machine are not retried.
+func (c *client) connect(fn func(*ssh.Client) error) error {
+	return c.connectContext(context.Background(), fn)
+}
+
+// connectContext is like connect but dials with the context.
+func (c *client) connectContext(ctx context.Context, fn func(*ssh.Client) error) error {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.conn.client != nil {
+		err := fn(c.conn.client)
+		if err == nil {
+			return nil
+		}
+		_, ok := err.(*ssh.OpenChannelError)
+		if ok {
+			return err
+		}
+		c.conn.client.Close()
+		c.conn.client = nil
+	}
+	s, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn.client = s
+	return fn(s)
+}
+
+// dial returns a new SSH connection to the machine by way of
+// the agent if it is running, otherwise directly.
+func (c *client) dial(ctx context.Context) (*ssh.Client, error) {
+	if !c.conn.direct {
+		s, err := c.dialAgent(ctx)
+		if err == nil {
+			return s, nil
+		}
+	}
+	return c.dialDirect(ctx)
+}
+
+// dialDirect returns a new SSH connection to the machine.
+func (c *client) dialDirect(ctx context.Context) (*ssh.Client, error) {
 	ipv4, err := c.getIPv4()
 	if err != nil {
 		return nil, err
@@ -31,45 +110,140 @@ func (c *client) newSession() (*ssh.Session, error) {
 		User:            username,
 		Auth:            []ssh.AuthMethod{ssh.PublicKeys(privateKey)},
 		HostKeyCallback: knownHosts,
-		Timeout:         90 * time.Second,
 	}
 	addr := net.JoinHostPort(ipv4, c.flags.port)
-	s, err := ssh.Dial("tcp", addr, config)
+	d := net.Dialer{Timeout: 90 * time.Second}
+	nconn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return s.NewSession()
+	return newClientConn(ctx, nconn, addr, config)
+}
+
+// newClientConn is like ssh.NewClientConn but the handshake
+// is abandoned and nconn closed once ctx is done.
+func newClientConn(ctx context.Context, nconn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	stop := closeOnDone(ctx, nconn)
+	sconn, chans, reqs, err := ssh.NewClientConn(nconn, addr, config)
+	stop()
+	if err != nil {
+		nconn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return ssh.NewClient(sconn, chans, reqs), nil
+}
+
+// closeOnDone closes cl once ctx is done unless stopped first.
+func closeOnDone(ctx context.Context, cl io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cl.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// close closes the cached connection, if any.
+func (c *client) close() error {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.conn.sftp != nil {
+		c.conn.sftp.Close()
+		c.conn.sftp = nil
+	}
+	if c.conn.client == nil {
+		return nil
+	}
+	err := c.conn.client.Close()
+	c.conn.client = nil
+	return err
 }
 
 func (c *client) run(command string, args ...string) ([]byte, []byte, error) {
 	return c.runWithStdin(nil, command, args...)
 }
 
-func (c *client) runWithStdin(stdin io.Reader, command string, args ...string) ([]byte, []byte, error) {
-	session, err := c.newSession()
+// runContext is like run but the connection is closed once ctx is
+// done such that an unresponsive machine does not block the caller.
+// The connection is redialed on next use.
+func (c *client) runContext(ctx context.Context, command string, args ...string) ([]byte, []byte, error) {
+	var session *ssh.Session
+	var stop func()
+	fn := func(s *ssh.Client) error {
+		stop = closeOnDone(ctx, s)
+		var err error
+		session, err = s.NewSession()
+		if err != nil {
+			stop()
+		}
+		return err
+	}
+	err := c.connectContext(ctx, fn)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer stop()
 	defer session.Close()
 	stdout := bytes.Buffer{}
 	stderr := bytes.Buffer{}
-	session.Stdin = stdin
 	session.Stdout = &stdout
 	session.Stderr = &stderr
 	err = session.Run(quote(command, args...))
 	if err != nil {
-		return nil, nil, err
+		return nil, stderr.Bytes(), err
 	}
 	return stdout.Bytes(), stderr.Bytes(), nil
 }
 
+func (c *client) runWithStdin(stdin io.Reader, command string, args ...string) ([]byte, []byte, error) {
+	stdout := bytes.Buffer{}
+	stderr, err := c.stream(stdin, &stdout, command, args...)
+	if err != nil {
+		return nil, stderr, err
+	}
+	return stdout.Bytes(), stderr, nil
+}
+
+// stream runs the command with stdin and stdout connected directly
+// to the session, without buffering, and returns the stderr output
+// regardless of whether the command succeeded.
+func (c *client) stream(stdin io.Reader, stdout io.Writer, command string, args ...string) ([]byte, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	stderr := bytes.Buffer{}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	err = session.Run(quote(command, args...))
+	if err != nil {
+		return stderr.Bytes(), err
+	}
+	return stderr.Bytes(), nil
+}
+
 func (c *client) runWithOutput(command string, args ...string) error {
+	return c.runWithInput(c.config.Stdin, command, args...)
+}
+
+// runWithInput runs the command with stdin connected to the session
+// and output streamed to stdout and stderr. A pseudo-terminal is
+// requested if stdin is a terminal.
+func (c *client) runWithInput(stdin io.Reader, command string, args ...string) error {
 	session, err := c.newSession()
 	if err != nil {
 		return err
 	}
 	defer session.Close()
-	session.Stdin = c.config.Stdin
+	session.Stdin = stdin
 	session.Stdout = c.config.Stdout
 	session.Stderr = c.config.Stderr
 	modes := ssh.TerminalModes{
@@ -1,17 +1,31 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
 	"net"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
+
+	"github.com/pkg/sftp"
 )
 
-type testSSH struct{}
+type testSSH struct {
+	port  string
+	root  string // machine /acrobox directory
+	bin   string // machine commands faked with scripts
+	conns int32  // accepted connections
+}
 
-func newTestSSH(t *testing.T, home string, privateHostKey ssh.Signer) string {
+func newTestSSH(t *testing.T, home string, privateHostKey ssh.Signer) *testSSH {
 	t.Helper()
 	config := &ssh.ServerConfig{
 		PublicKeyCallback: func(c ssh.ConnMetadata, pub ssh.PublicKey) (*ssh.Permissions, error) {
@@ -45,9 +59,17 @@ func newTestSSH(t *testing.T, home string, privateHostKey ssh.Signer) string {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	ss := &testSSH{}
+	ss := &testSSH{port: port, root: filepath.Join(home, "machine"), bin: filepath.Join(home, "machine-bin")}
+	err = os.MkdirAll(ss.root, 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = os.MkdirAll(ss.bin, 0770)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	go ss.serve(listener, config)
-	return port
+	return ss
 }
 
 func (s *testSSH) serve(listener net.Listener, config *ssh.ServerConfig) {
@@ -66,15 +88,23 @@ func (s *testSSH) handleConnection(nconn net.Conn, config *ssh.ServerConfig) {
 	if err != nil {
 		return
 	}
+	atomic.AddInt32(&s.conns, 1)
 	defer sconn.Close()
 	go ssh.DiscardRequests(reqs)
+	// Machines are told apart by the loopback address dialed.
+	machine, _, _ := net.SplitHostPort(sconn.LocalAddr().String())
 	for ch := range chans {
-		go s.handleNewChannel(ch)
+		go s.handleNewChannel(ch, machine)
 	}
 }
 
-func (s *testSSH) handleNewChannel(nch ssh.NewChannel) {
-	if nch.ChannelType() != "session" {
+func (s *testSSH) handleNewChannel(nch ssh.NewChannel, machine string) {
+	switch nch.ChannelType() {
+	case "session":
+	case "direct-tcpip":
+		nch.Reject(ssh.Prohibited, "administratively prohibited")
+		return
+	default:
 		nch.Reject(ssh.UnknownChannelType, "unknown channel type")
 		return
 	}
@@ -82,10 +112,10 @@ func (s *testSSH) handleNewChannel(nch ssh.NewChannel) {
 	if err != nil {
 		return
 	}
-	go s.handle(ch, reqs)
+	go s.handle(ch, reqs, machine)
 }
 
-func (s *testSSH) handle(ch ssh.Channel, reqs <-chan *ssh.Request) error {
+func (s *testSSH) handle(ch ssh.Channel, reqs <-chan *ssh.Request, machine string) error {
 	for req := range reqs {
 		switch req.Type {
 		case "exec":
@@ -94,23 +124,131 @@ func (s *testSSH) handle(ch ssh.Channel, reqs <-chan *ssh.Request) error {
 			if err != nil {
 				return err
 			}
+			if strings.HasPrefix(payload.Value, "sh '-c' ") || s.faked(payload.Value) {
+				err = req.Reply(true, nil)
+				if err != nil {
+					return err
+				}
+				go ssh.DiscardRequests(reqs)
+				return s.shell(ch, payload.Value, machine)
+			}
+			var stdout []byte
 			switch payload.Value {
 			case "docker container inspect -f {{.Id}} acroboxd":
 			case "docker exec acroboxd acroboxd status":
 			default:
-				return req.Reply(false, nil)
+				filename, ok := unquoteTestCommand(payload.Value, "sha256sum")
+				if !ok {
+					return req.Reply(false, nil)
+				}
+				b, err := os.ReadFile(filename)
+				if err != nil {
+					return req.Reply(false, nil)
+				}
+				sum := sha256.Sum256(b)
+				stdout = []byte(hex.EncodeToString(sum[:]) + "  " + filename + "\n")
 			}
 			err = req.Reply(true, nil)
 			if err != nil {
 				return err
 			}
+			_, err = ch.Write(stdout)
+			if err != nil {
+				return err
+			}
 			status := struct{ Status uint32 }{uint32(0)}
 			_, err = ch.SendRequest("exit-status", false, ssh.Marshal(&status))
 			if err != nil {
 				return err
 			}
 			return ch.Close()
+		case "subsystem":
+			var payload = struct{ Value string }{}
+			err := ssh.Unmarshal(req.Payload, &payload)
+			if err != nil {
+				return err
+			}
+			if payload.Value != "sftp" {
+				return req.Reply(false, nil)
+			}
+			err = req.Reply(true, nil)
+			if err != nil {
+				return err
+			}
+			go ssh.DiscardRequests(reqs)
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return err
+			}
+			server.Serve()
+			return ch.Close()
 		}
 	}
 	return nil
 }
+
+// fake installs a shell script as the named machine command.
+func (s *testSSH) fake(t *testing.T, name, script string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(s.bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// faked reports whether the command name of s was faked.
+func (s *testSSH) faked(command string) bool {
+	name := strings.SplitN(command, " ", 2)[0]
+	_, err := os.Stat(filepath.Join(s.bin, name))
+	return name != "" && err == nil
+}
+
+// shell runs the command with a local shell with /acrobox
+// paths rewritten to the machine root of the test home and
+// faked commands taking precedence. The machine address is
+// available to fakes as $MACHINE.
+func (s *testSSH) shell(ch ssh.Channel, command, machine string) error {
+	command = strings.ReplaceAll(command, "'/acrobox/", "'"+s.root+"/")
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "PATH="+s.bin+string(os.PathListSeparator)+os.Getenv("PATH"), "MACHINE="+machine)
+	cmd.Stdout = ch
+	cmd.Stderr = ch.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdin, ch)
+		stdin.Close()
+	}()
+	code := 0
+	err = cmd.Wait()
+	if err != nil {
+		eerr, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
+		}
+		code = eerr.ExitCode()
+	}
+	status := struct{ Status uint32 }{uint32(code)}
+	_, err = ch.SendRequest("exit-status", false, ssh.Marshal(&status))
+	if err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+// unquoteTestCommand returns the single argument of a command
+// quoted by quote if the command name matches.
+func unquoteTestCommand(s, command string) (string, bool) {
+	prefix := command + " '"
+	if !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, "'") {
+		return "", false
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, prefix), "'")
+	return strings.ReplaceAll(s, "'\"'\"'", "'"), true
+}
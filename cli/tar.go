@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeTar writes the directory tree rooted at dir to w as a tar
+// stream. Entry names are relative to the parent of dir such that
+// extracting the stream recreates the directory by its base name.
+//
+// File modes, modification times, symbolic links, and empty
+// directories are preserved.
+func writeTar(w io.Writer, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	err = addTree(tw, dir, filepath.Dir(dir), nil)
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addTree writes the directory tree rooted at dir to tw with entry
+// names relative to root. Entries for which skip returns true are
+// omitted along with their contents if they are directories.
+func addTree(tw *tar.Writer, dir, root string, skip func(name string, fi os.FileInfo) bool) error {
+	fn := func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, filename)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		name = filepath.ToSlash(name)
+		if skip != nil && skip(name, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(filename)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}
+	return filepath.Walk(dir, fn)
+}
+
+// extractTar extracts the tar stream from r into dir.
+//
+// Entries that would resolve outside of dir are rejected, as are
+// entries outside of the root entry when root is not empty.
+// Directory modification times are applied last as
+// extracting their contents would otherwise reset them.
+func extractTar(r io.Reader, dir, root string) error {
+	type dirTime struct {
+		name    string
+		modTime time.Time
+	}
+	dirs := make([]dirTime, 0)
+	links := make([]string, 0)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if !isLocalName(name, links) || !isRootName(name, root) {
+			return fmt.Errorf("Archive entry '%s' is outside of the target directory.", hdr.Name)
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			err = checkParents(dir, name)
+			if err != nil {
+				return err
+			}
+			err = removeExisting(filename)
+			if err != nil {
+				return err
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(filename, 0750)
+			if err != nil {
+				return err
+			}
+			err = os.Chmod(filename, mode)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{filename, hdr.ModTime})
+			continue
+		case tar.TypeReg:
+			err = extractFile(tr, filename, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, filename)
+			if err != nil {
+				return err
+			}
+			links = append(links, name)
+			continue
+		case tar.TypeLink:
+			link := path.Clean(hdr.Linkname)
+			if !isLocalName(link, links) || !isRootName(link, root) {
+				return fmt.Errorf("Archive entry '%s' links outside of the target directory.", hdr.Name)
+			}
+			err = checkParents(dir, link)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dir, filepath.FromSlash(link))
+			err = os.Link(target, filename)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = os.Chtimes(filename, hdr.ModTime, hdr.ModTime)
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := os.Chtimes(dirs[i].name, dirs[i].modTime, dirs[i].modTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLocalName reports whether the cleaned slash-separated name
+// stays within the extraction directory without being, or passing
+// through, any of the previously extracted symbolic links.
+func isLocalName(name string, links []string) bool {
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return false
+	}
+	for _, link := range links {
+		if name == link || strings.HasPrefix(name, link+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// isRootName reports whether the cleaned slash-separated name is the
+// root entry or within it. Every name is within an empty root.
+func isRootName(name, root string) bool {
+	return root == "" || name == root || strings.HasPrefix(name, root+"/")
+}
+
+// checkParents returns an error if any existing parent of the cleaned
+// slash-separated name within dir is not a directory, such as a
+// symbolic link, as creating or removing the entry would follow it.
+func checkParents(dir, name string) error {
+	filename := dir
+	parents := strings.Split(name, "/")
+	for _, elem := range parents[:len(parents)-1] {
+		filename = filepath.Join(filename, elem)
+		fi, err := os.Lstat(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("Archive entry '%s' is outside of the target directory.", name)
+		}
+	}
+	return nil
+}
+
+// removeExisting removes the file at filename, if any, unless it is a
+// directory such that creating an entry never follows a symbolic link
+// or writes through a hard link that is already in the target.
+func removeExisting(filename string) error {
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	return os.Remove(filename)
+}
+
+// extractFile writes r to filename with the given permissions.
+func extractFile(r io.Reader, filename string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
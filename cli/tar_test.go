@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTar(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirs := []string{"src", "src/empty", "src/sub dir"}
+	files := map[string]os.FileMode{
+		"src/run.sh":          0750,
+		"src/sub dir/a b.txt": 0640,
+	}
+	for _, name := range dirs {
+		err := os.MkdirAll(filepath.Join(filepath.Dir(src), name), 0750)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for name, mode := range files {
+		filename := filepath.Join(filepath.Dir(src), name)
+		err := os.WriteFile(filename, []byte(name), mode)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = os.Chtimes(filename, mtime, mtime)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	err := os.Symlink("run.sh", filepath.Join(src, "link"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	err = writeTar(&buf, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dst := t.TempDir()
+	err = extractTar(&buf, dst, "src")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, mode := range files {
+		filename := filepath.Join(dst, name)
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("mode %s\nhave %s\nwant %s", name, fi.Mode().Perm(), mode)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("mtime %s\nhave %s\nwant %s", name, fi.ModTime(), mtime)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dst, "src/empty"))
+	if err != nil || !fi.IsDir() {
+		t.Errorf("empty directory should exist")
+	}
+	link, err := os.Readlink(filepath.Join(dst, "src/link"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "run.sh" {
+		t.Errorf("symlink\nhave '%s'\nwant '%s'", link, "run.sh")
+	}
+}
+
+func TestExtractTarTraversal(t *testing.T) {
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "victim")
+	tests := [][]*tar.Header{
+		{{Name: "../evil", Typeflag: tar.TypeReg}},
+		{{Name: "/evil", Typeflag: tar.TypeReg}},
+		{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+			{Name: "link/evil", Typeflag: tar.TypeReg},
+		},
+		{
+			{Name: "x", Typeflag: tar.TypeSymlink, Linkname: victim},
+			{Name: "x", Typeflag: tar.TypeReg, Size: 5},
+		},
+		{
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "d", Typeflag: tar.TypeDir},
+		},
+	}
+	for _, hdrs := range tests {
+		err := os.WriteFile(victim, []byte("safe"), 0640)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = os.Chmod(outside, 0750)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = extractTar(newTestTar(t, hdrs), t.TempDir(), "")
+		if err == nil {
+			t.Errorf("extractTar %s should fail", hdrs[len(hdrs)-1].Name)
+		}
+		assertUntouched(t, victim, outside)
+	}
+	// Links already in the target are replaced rather than followed.
+	dir := t.TempDir()
+	err := os.Symlink(victim, filepath.Join(dir, "x"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = os.Symlink(outside, filepath.Join(dir, "d"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, hdr := range []*tar.Header{
+		{Name: "d/victim", Typeflag: tar.TypeReg, Size: 5},
+		{Name: "d/victim", Typeflag: tar.TypeDir},
+		{Name: "y", Typeflag: tar.TypeLink, Linkname: "d/victim"},
+	} {
+		err = extractTar(newTestTar(t, []*tar.Header{hdr}), dir, "")
+		if err == nil {
+			t.Errorf("extractTar %s below an existing link should fail", hdr.Name)
+		}
+		assertUntouched(t, victim, outside)
+	}
+	hdrs := []*tar.Header{
+		{Name: "x", Typeflag: tar.TypeReg, Size: 5},
+		{Name: "d", Typeflag: tar.TypeDir},
+	}
+	err = extractTar(newTestTar(t, hdrs), dir, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertUntouched(t, victim, outside)
+	for _, name := range []string{"x", "d"} {
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("existing link '%s' should be replaced", name)
+		}
+	}
+}
+
+// newTestTar returns a tar archive of the headers. Regular
+// files are filled with "pwned" up to their size.
+func newTestTar(t *testing.T, hdrs []*tar.Header) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		hdr.Mode = 0640
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if hdr.Size > 0 {
+			_, err = tw.Write([]byte("pwned")[:hdr.Size])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	tw.Close()
+	return &buf
+}
+
+func assertUntouched(t *testing.T, victim, outside string) {
+	t.Helper()
+	b, err := os.ReadFile(victim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != "safe" {
+		t.Errorf("file outside of the target should be untouched\nhave %q", b)
+	}
+	fi, err := os.Stat(outside)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0750 {
+		t.Errorf("directory outside of the target should be untouched\nhave %v", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+
+	"acrobox.io/docs"
+)
+
+// localDocs holds the usage of commands that are not yet published
+// in acrobox.io/docs, laid out the same way. Pages are removed from
+// here once a version of acrobox.io/docs with them is vendored.
+//
+//go:embed docs
+var localDocs embed.FS
+
+// usageFS returns the documentation of acrobox.io/docs with
+// the pages of localDocs taking precedence.
+func usageFS() fs.FS {
+	local, err := fs.Sub(localDocs, "docs")
+	if err != nil {
+		panic(err)
+	}
+	return layeredFS{local, docs.FS}
+}
+
+// layeredFS opens files from the first file system that has them.
+type layeredFS []fs.FS
+
+// Open implements the io/fs.FS interface.
+func (l layeredFS) Open(name string) (fs.File, error) {
+	var err error
+	for _, fsys := range l {
+		var f fs.File
+		f, err = fsys.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	topics := []string{
+		"",
+		"init",
+		"agent",
+		"status",
+		"deploy",
+		"pull",
+		"push",
+		"build",
+		"deploys",
+		"rollback",
+		"release/set",
+		"release/get",
+		"release/del",
+		"lock/break",
+		"restore",
+		"promote",
+		"plan",
+		"apply",
+		"config/export",
+		"config/import",
+		"migrate",
+		"resize",
+		"volume/resize",
+		"regions",
+		"sizes",
+		"getting-started",
+	}
+	for _, topic := range topics {
+		var stdout bytes.Buffer
+		config := &Config{
+			Args:   []string{"abx", "help", topic},
+			Home:   t.TempDir(),
+			Stdout: &stdout,
+			Stderr: io.Discard,
+		}
+		err := Run(config)
+		if err != nil {
+			t.Errorf("help %s: unexpected error: %v", topic, err)
+			continue
+		}
+		want := "# abx " + topic + "\n"
+		switch topic {
+		case "":
+			want = "# Acrobox CLI\n"
+		case "getting-started":
+			want = "# Getting Started\n"
+		}
+		if !strings.HasPrefix(stdout.String(), want) {
+			t.Errorf("help %s\nhave %q\nwant prefix %q", topic, stdout.String(), want)
+		}
+	}
+}
@@ -24,3 +24,47 @@ type acroboxdStatus struct {
 	MemStatsMemoryAlloc       uint64        `json:"memstats_memory_alloc"` // bytes
 	MemStatsMemoryCount       uint64        `json:"memstats_memory_count"` // count
 }
+
+// acroboxdConfig represents the subset of the machine
+// configuration file /acrobox/config.json of interest to abx.
+type acroboxdConfig struct {
+	Containers  []acroboxdContainer          `json:"containers"`
+	Environment map[string]map[string]string `json:"environment"`
+}
+
+// acroboxdContainer represents a container configured with
+// abx add as listed by acroboxd list -format json.
+type acroboxdContainer struct {
+	Name    string   `json:"name"`
+	Image   string   `json:"image"`
+	Command []string `json:"command,omitempty"`
+	Site    string   `json:"site,omitempty"`
+	Port    string   `json:"port,omitempty"`
+	Task    string   `json:"task,omitempty"`
+}
+
+// acroboxdDatabase represents a database as
+// listed by acroboxd db/list -format json.
+type acroboxdDatabase struct {
+	Name string `json:"name"`
+}
+
+// dockerContainer represents the subset of docker
+// container inspect output that is of interest to abx.
+type dockerContainer struct {
+	Name         string `json:"Name"`
+	RestartCount int    `json:"RestartCount"`
+	State        struct {
+		Status   string `json:"Status"`
+		Running  bool   `json:"Running"`
+		ExitCode int    `json:"ExitCode"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health,omitempty"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	acrobox.io/docs v0.0.0-20220423132608-202b33be6d8f
+	github.com/pkg/sftp v1.13.5
 	github.com/pnelson/cli v0.0.0-20220705013849-97ae5e6f3ae1
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
 	golang.org/x/sys v0.0.0-20220731174439-a90be440212d // indirect